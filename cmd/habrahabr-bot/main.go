@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -19,22 +21,39 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if config.CheckConfig {
+		fmt.Print(config.Describe())
+		fmt.Println("Конфигурация корректна")
+		return
+	}
 
 	// Инициализация advanced-log
 	logging.LogInfo("Старт программы")
 
 	// Инициализация базы данных c пользователями
-	err = userdb.Open("data/users.db")
+	err = userdb.Open(config.DataPath("users.db"))
 	if err != nil {
 		logging.LogFatalError("main", "попытка открыть базу данных с пользователями", err)
 	}
 
 	// Получение корректных id
-	err = bot.ParseCorrectIDS("data/ids.json")
+	err = bot.ParseCorrectIDS(config.DataPath("ids.json"))
 	if err != nil {
 		logging.LogFatalError("main", "попытка распарсить список id", err)
 	}
 
+	// Регистрация источников статей
+	interval := config.Get().Delay
+	for _, src := range []bot.Source{
+		bot.NewHabrSource("ru", interval),
+		bot.NewHabrSource("en", interval),
+	} {
+		err = bot.RegisterSource(src)
+		if err != nil {
+			logging.LogFatalError("main", "попытка зарегистрировать источник статей", err)
+		}
+	}
+
 	// Инициализация бота
 	logging.LogInfo("Инициализация бота")
 	habrBot, err := bot.NewBot()
@@ -44,20 +63,30 @@ func main() {
 
 	// Запуск бота
 	logging.LogInfo("Запуск бота")
-	stopChan := make(chan struct{})
-	go habrBot.StartPooling(stopChan)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		habrBot.StartPooling(ctx)
+		close(stopped)
+	}()
 
 	// Перехватываем сигналы
 	sigChan := make(chan os.Signal, 1)
-	// SIGTERM для Сервера (htop kill 15), SIGINT для Windows (Ctrl+C)
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
-	// Ждём сигнала
-	<-sigChan
-	// Останавливаем бота
-	close(stopChan)
-	// Ждём пока все функции завершатся.
-	// Из-за того, что бот больше не принимает новые сообщений, функции не будут вызываться
-	time.Sleep(2 * time.Second)
+	// SIGTERM для Сервера (htop kill 15), SIGINT для Windows (Ctrl+C), SIGHUP – перезагрузка конфигурации
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	// Ждём сигнала остановки
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if _, err := habrBot.Reload(); err != nil {
+			logging.LogMinorError("main", "попытка перезагрузить конфигурацию", err)
+		}
+	}
+	// Останавливаем бота и ждём, пока остановятся фоновые задачи и отправятся оставшиеся сообщения
+	logging.LogInfo("Остановка бота")
+	cancel()
+	<-stopped
 	// Закрытие базы данных
 	userdb.Close()
 	logging.LogInfo("Остановка работы")
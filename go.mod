@@ -1,6 +1,6 @@
 module github.com/ShoshinNikita/habrahabr-bot-go
 
-go 1.12
+go 1.15
 
 require (
 	github.com/PuerkitoBio/goquery v1.5.0 // indirect
@@ -15,4 +15,5 @@ require (
 	golang.org/x/net v0.0.0-20190509222800-a4d6f7feada5 // indirect
 	golang.org/x/sys v0.0.0-20190509141414-a5b02f93d862 // indirect
 	gopkg.in/telegram-bot-api.v4 v4.6.4
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,413 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/config"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+const (
+	// Максимальное количество использований одного кода приглашения
+	maxInviteUses = 100
+	// Максимальное количество пользователей в списке /banned
+	maxBannedList = 100
+)
+
+// ParseCorrectIDS разрешает доступ id из json-файла (если он есть).
+// Файл используется только для начального заполнения: доступ пользователей хранится в базе данных
+func ParseCorrectIDS(path string) error {
+	ids, err := readCorrectIDs(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	added, err := userdb.AllowUsers(formatIDs(ids))
+	if err != nil {
+		return err
+	}
+	if len(added) > 0 {
+		logging.LogInfo("Доступ разрешён id из %s: %v", path, added)
+	}
+	return nil
+}
+
+// readCorrectIDs читает список корректных id из json-файла
+func readCorrectIDs(path string) ([]int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int64{}
+	err = json.Unmarshal(data, &ids)
+	return ids, err
+}
+
+func formatIDs(ids []int64) []string {
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, strconv.FormatInt(id, 10))
+	}
+	return result
+}
+
+// isAdmin проверяет, является ли пользователь администратором
+func isAdmin(id int64) bool {
+	for _, admin := range config.Get().Admins {
+		if admin == id {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowed проверяет, может ли бот взаимодействовать с id. Администраторам доступ разрешён всегда
+func isAllowed(id int64) bool {
+	if isAdmin(id) {
+		return true
+	}
+
+	access, ok, err := userdb.GetAccess(strconv.FormatInt(id, 10))
+	if err != nil {
+		logging.LogMinorError("isAllowed", fmt.Sprintf("UserID: %d", id), err)
+		return false
+	}
+	return ok && access.Status == userdb.AccessAllowed
+}
+
+// checkAdmin проверяет, что команду отправил администратор. Если нет – отправляет пользователю ошибку
+func (bot *Bot) checkAdmin(msg *tgbotapi.Message) bool {
+	if isAdmin(msg.Chat.ID) {
+		return true
+	}
+	bot.sendErrorToUser("команда доступна только администраторам", msg.Chat.ID)
+	return false
+}
+
+// handleUnknownUser обрабатывает сообщение пользователя без доступа: принимает код приглашения (/start <код>)
+// или отправляет администраторам запрос на доступ. Сообщения пользователей с запрещённым доступом игнорируются
+func (bot *Bot) handleUnknownUser(msg *tgbotapi.Message) {
+	logging.LogInfo("Unknown ID: %d Username: %s Text: %s", msg.Chat.ID, msg.Chat.UserName, msg.Text)
+
+	id := strconv.FormatInt(msg.Chat.ID, 10)
+	access, ok, err := userdb.GetAccess(id)
+	if err != nil {
+		logging.LogMinorError("handleUnknownUser", "попытка получить доступ пользователя "+id, err)
+		return
+	}
+	if ok && access.Status == userdb.AccessDenied {
+		return
+	}
+
+	if msg.Command() == "start" && strings.TrimSpace(msg.CommandArguments()) != "" {
+		bot.useInvite(msg, strings.TrimSpace(msg.CommandArguments()))
+		return
+	}
+
+	if len(config.Get().Admins) == 0 {
+		bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, "Неверный ID. Для подробностей писать @ShoshinNikita")
+		return
+	}
+
+	created, err := userdb.RequestAccess(id, msg.Chat.UserName)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "access",
+			AddInfo:  "попытка создать запрос на доступ"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	if !created {
+		bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, "Запрос на доступ ещё не рассмотрен")
+		return
+	}
+
+	text := "🔑 Запрос на доступ: " + userTitle(msg.Chat) + "\n" + html.EscapeString(msg.Text)
+	markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Разрешить", callbackAccess+userdb.AccessAllowed+":"+id),
+		tgbotapi.NewInlineKeyboardButtonData("❌ Запретить", callbackAccess+userdb.AccessDenied+":"+id),
+	))
+	bot.notifyAdmins(text, &markup)
+
+	bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, "Запрос на доступ отправлен администраторам. Когда его рассмотрят, придёт сообщение")
+}
+
+// useInvite разрешает доступ по коду приглашения и регистрирует пользователя
+func (bot *Bot) useInvite(msg *tgbotapi.Message, code string) {
+	ok, invite, err := userdb.UseInvite(code, strconv.FormatInt(msg.Chat.ID, 10), msg.Chat.UserName)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/start",
+			AddInfo:  "попытка использовать приглашение"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+	if !ok {
+		bot.sendErrorToUser("приглашение недействительно", msg.Chat.ID)
+		return
+	}
+
+	logging.LogInfo("Доступ по приглашению: ID: %d Username: %s", msg.Chat.ID, msg.Chat.UserName)
+	bot.notifyAdmins(fmt.Sprintf("🎟 %s получил доступ по приглашению (осталось использований: %d)",
+		userTitle(msg.Chat), invite.Uses), nil)
+
+	bot.start(msg)
+}
+
+// notifyAdmins отправляет сообщение всем администраторам
+func (bot *Bot) notifyAdmins(text string, markup *tgbotapi.InlineKeyboardMarkup) {
+	for _, admin := range config.Get().Admins {
+		message := tgbotapi.NewMessage(admin, text)
+		message.ParseMode = "HTML"
+		if markup != nil {
+			message.ReplyMarkup = markup
+		}
+		bot.messages <- message
+	}
+}
+
+// userTitle возвращает имя пользователя для сообщений администраторам
+func userTitle(chat *tgbotapi.Chat) string {
+	title := strings.TrimSpace(chat.FirstName + " " + chat.LastName)
+	if chat.UserName != "" {
+		title += " @" + chat.UserName
+	}
+	return html.EscapeString(strings.TrimSpace(title)) + " (<code>" + strconv.FormatInt(chat.ID, 10) + "</code>)"
+}
+
+// isDenied проверяет, запрещён ли доступ пользователю
+func isDenied(id int64) bool {
+	access, ok, err := userdb.GetAccess(strconv.FormatInt(id, 10))
+	if err != nil {
+		logging.LogMinorError("isDenied", fmt.Sprintf("UserID: %d", id), err)
+		return false
+	}
+	return ok && access.Status == userdb.AccessDenied
+}
+
+// changeAccess изменяет доступ пользователя и сообщает ему об этом.
+// Пользователь с запрещённым доступом перестаёт получать рассылку: его неотправленные сообщения
+// и отложенные статьи удаляются
+func (bot *Bot) changeAccess(id int64, status string, by int64) error {
+	idStr := strconv.FormatInt(id, 10)
+	err := userdb.SetAccess(idStr, status, "", by)
+	if err != nil {
+		return err
+	}
+
+	text := "Доступ к боту разрешён. Введите /start"
+	if status == userdb.AccessDenied {
+		text = "Доступ к боту запрещён"
+		if _, err := userdb.GetUser(idStr); err == nil {
+			if err := userdb.DeactivateUser(idStr, "denied"); err != nil {
+				logging.LogMinorError("changeAccess", "попытка деактивировать пользователя "+idStr, err)
+			}
+		}
+		n, err := userdb.DeleteUserQueue(idStr)
+		if err != nil {
+			logging.LogMinorError("changeAccess", "попытка удалить очередь пользователя "+idStr, err)
+		} else if n > 0 {
+			logging.LogInfo("Удалено из очереди пользователя %d: %d", id, n)
+		}
+	}
+	bot.messages <- tgbotapi.NewMessage(id, text)
+
+	logging.LogInfo("Доступ изменён: ID: %d Status: %s By: %d", id, status, by)
+	return nil
+}
+
+// accessFromCallback разрешает или запрещает доступ по кнопке под запросом (access:<статус>:<id>)
+func (bot *Bot) accessFromCallback(query *tgbotapi.CallbackQuery, data string) {
+	if !isAdmin(int64(query.From.ID)) {
+		bot.answerCallback(query, "Только для администраторов")
+		return
+	}
+
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 || (parts[0] != userdb.AccessAllowed && parts[0] != userdb.AccessDenied) {
+		bot.answerCallback(query, "Неверная кнопка")
+		return
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		bot.answerCallback(query, "Неверная кнопка")
+		return
+	}
+
+	err = bot.changeAccess(id, parts[0], int64(query.From.ID))
+	if err != nil {
+		logging.LogMinorError("accessFromCallback", "попытка изменить доступ пользователя "+parts[1], err)
+		bot.answerCallback(query, "Что-то пошло не так")
+		return
+	}
+
+	result := "✅ Доступ разрешён"
+	if parts[0] == userdb.AccessDenied {
+		result = "❌ Доступ запрещён"
+	}
+	if query.From.UserName != "" {
+		result += " (@" + query.From.UserName + ")"
+	}
+	bot.editMessage(query.Message.Chat.ID, query.Message.MessageID, html.EscapeString(query.Message.Text)+"\n\n"+result, nil)
+	bot.answerCallback(query, result)
+}
+
+// parseUserID разбирает id пользователя из аргументов команды
+func parseUserID(msg *tgbotapi.Message) (int64, bool) {
+	id, err := strconv.ParseInt(strings.TrimSpace(msg.CommandArguments()), 10, 64)
+	return id, err == nil
+}
+
+// allowUser разрешает доступ пользователю (пример: /allow 123456)
+func (bot *Bot) allowUser(msg *tgbotapi.Message) {
+	if !bot.checkAdmin(msg) {
+		return
+	}
+
+	id, ok := parseUserID(msg)
+	if !ok {
+		bot.sendErrorToUser("укажите id пользователя (пример: /allow 123456)", msg.Chat.ID)
+		return
+	}
+
+	err := bot.changeAccess(id, userdb.AccessAllowed, msg.Chat.ID)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...allow",
+			AddInfo:  "попытка разрешить доступ"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Доступ пользователю %d разрешён", id))
+}
+
+// denyUser запрещает доступ пользователю (пример: /deny 123456 или /ban 123456)
+func (bot *Bot) denyUser(msg *tgbotapi.Message) {
+	if !bot.checkAdmin(msg) {
+		return
+	}
+
+	id, ok := parseUserID(msg)
+	if !ok {
+		bot.sendErrorToUser("укажите id пользователя (пример: /"+msg.Command()+" 123456)", msg.Chat.ID)
+		return
+	}
+	if isAdmin(id) {
+		bot.sendErrorToUser("нельзя запретить доступ администратору", msg.Chat.ID)
+		return
+	}
+
+	err := bot.changeAccess(id, userdb.AccessDenied, msg.Chat.ID)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/..." + msg.Command(),
+			AddInfo:  "попытка запретить доступ"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Доступ пользователю %d запрещён", id))
+}
+
+// getBanned отправляет список пользователей с запрещённым доступом
+func (bot *Bot) getBanned(msg *tgbotapi.Message) {
+	if !bot.checkAdmin(msg) {
+		return
+	}
+
+	list, err := userdb.GetAccessList(userdb.AccessDenied)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/banned",
+			AddInfo:  "попытка получить список пользователей"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	if len(list) == 0 {
+		bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, "Список заблокированных пользователей пуст")
+		return
+	}
+
+	text := "Заблокированные пользователи:\n"
+	for i, access := range list {
+		if i == maxBannedList {
+			text += fmt.Sprintf("… и ещё %d", len(list)-maxBannedList)
+			break
+		}
+		text += "* <code>" + access.ID + "</code>"
+		if access.Username != "" {
+			text += " @" + html.EscapeString(access.Username)
+		}
+		text += " – " + access.Updated.Format("02.01.2006") + "\n"
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	message.ParseMode = "HTML"
+	bot.messages <- message
+}
+
+// createInvite создаёт ссылку-приглашение (пример: /invite, /invite 5 – для 5 пользователей)
+func (bot *Bot) createInvite(msg *tgbotapi.Message) {
+	if !bot.checkAdmin(msg) {
+		return
+	}
+
+	uses := 1
+	if args := strings.TrimSpace(msg.CommandArguments()); args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 || n > maxInviteUses {
+			bot.sendErrorToUser("количество использований должно быть от 1 до "+strconv.Itoa(maxInviteUses), msg.Chat.ID)
+			return
+		}
+		uses = n
+	}
+
+	code, err := userdb.CreateInvite(msg.Chat.ID, uses)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/invite",
+			AddInfo:  "попытка создать приглашение"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	link := "https://t.me/" + bot.botAPI.Self.UserName + "?start=" + code
+	text := fmt.Sprintf("Приглашение (использований: %d):\n%s", uses, link)
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	message.DisableWebPagePreview = true
+	bot.messages <- message
+}
@@ -0,0 +1,355 @@
+package bot
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+const (
+	// Количество тегов в /stats
+	statsTopTags = 10
+	// Сколько хранится рассылка, ожидающая подтверждения
+	broadcastTTL = time.Hour
+)
+
+// Время запуска бота
+var startedAt = time.Now()
+
+// sentToday – количество сообщений, успешно отправленных с начала суток. Счётчик хранится только в памяти,
+// поэтому после перезапуска считаются сообщения, отправленные с момента запуска
+var sentToday = struct {
+	sync.Mutex
+	day   string
+	count int
+}{}
+
+// countSent увеличивает счётчик отправленных сообщений
+func countSent() {
+	day := time.Now().Format("2006-01-02")
+
+	sentToday.Lock()
+	if sentToday.day != day {
+		sentToday.day = day
+		sentToday.count = 0
+	}
+	sentToday.count++
+	sentToday.Unlock()
+}
+
+// getSentToday возвращает количество сообщений, отправленных сегодня, и время, с которого они считаются
+// (начало суток или время запуска бота)
+func getSentToday() (int, time.Time) {
+	now := time.Now()
+	day := now.Format("2006-01-02")
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if startedAt.After(since) {
+		since = startedAt
+	}
+
+	sentToday.Lock()
+	defer sentToday.Unlock()
+	if sentToday.day != day {
+		return 0, since
+	}
+	return sentToday.count, since
+}
+
+// getStats отправляет статистику пользователей
+func (bot *Bot) getStats(msg *tgbotapi.Message) {
+	if !bot.checkAdmin(msg) {
+		return
+	}
+
+	users, err := userdb.GetAllUsers()
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/stats",
+			AddInfo:  "попытка получить список пользователей"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	var active, stopped, blocked int
+	tags := make(map[string]int)
+	for _, user := range users {
+		switch {
+		case !user.Active:
+			blocked++
+			continue
+		case user.Mailout:
+			active++
+		default:
+			stopped++
+		}
+		for _, tag := range user.Tags {
+			tags[tag]++
+		}
+	}
+
+	pending, err := userdb.GetAccessList(userdb.AccessPending)
+	if err != nil {
+		logging.LogMinorError("getStats", "попытка получить запросы на доступ", err)
+	}
+
+	text := fmt.Sprintf("📊 <b>Пользователи</b>: %d\n"+
+		"* получают рассылку: %d\n"+
+		"* приостановили рассылку: %d\n"+
+		"* заблокировали бота или удалили аккаунт: %d\n"+
+		"* ожидают доступа: %d\n",
+		userdb.GetUsersNumber(), active, stopped, blocked, len(pending))
+
+	if len(tags) > 0 {
+		text += "\n<b>Популярные теги</b>:\n"
+		for i, tag := range topTags(tags, statsTopTags) {
+			text += strconv.Itoa(i+1) + ") " + html.EscapeString(tag) + " – " + strconv.Itoa(tags[tag]) + "\n"
+		}
+	}
+
+	sent, since := getSentToday()
+	text += "\n📨 Отправлено сообщений с " + since.Format("15:04") + ": " + strconv.Itoa(sent)
+	if since.Equal(startedAt) {
+		text += " (счётчик сбрасывается при перезапуске)"
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	message.ParseMode = "HTML"
+	bot.messages <- message
+}
+
+// topTags возвращает n самых популярных тегов
+func topTags(tags map[string]int, n int) []string {
+	result := make([]string, 0, len(tags))
+	for tag := range tags {
+		result = append(result, tag)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if tags[result[i]] != tags[result[j]] {
+			return tags[result[i]] > tags[result[j]]
+		}
+		return result[i] < result[j]
+	})
+
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// getUserInfo отправляет теги и настройки пользователя (пример: /user 123456)
+func (bot *Bot) getUserInfo(msg *tgbotapi.Message) {
+	if !bot.checkAdmin(msg) {
+		return
+	}
+
+	id, ok := parseUserID(msg)
+	if !ok {
+		bot.sendErrorToUser("укажите id пользователя (пример: /user 123456)", msg.Chat.ID)
+		return
+	}
+	idStr := strconv.FormatInt(id, 10)
+
+	access, hasAccess, err := userdb.GetAccess(idStr)
+	if err != nil {
+		logging.LogMinorError("getUserInfo", "попытка получить доступ пользователя "+idStr, err)
+	}
+
+	text := "👤 <b>Пользователь</b> <code>" + idStr + "</code>"
+	if access.Username != "" {
+		text += " @" + html.EscapeString(access.Username)
+	}
+	text += "\nДоступ: "
+	switch {
+	case isAdmin(id):
+		text += "администратор"
+	case hasAccess:
+		text += access.Status + " (" + access.Updated.Format("02.01.2006 15:04") + ")"
+	default:
+		text += "нет записи"
+	}
+	text += "\n"
+
+	user, err := userdb.GetUser(idStr)
+	if err == userdb.ErrNoSuchUser {
+		text += "\nПользователь не запускал бота"
+		message := tgbotapi.NewMessage(msg.Chat.ID, text)
+		message.ParseMode = "HTML"
+		bot.messages <- message
+		return
+	}
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/user",
+			AddInfo:  "попытка получить данные пользователя " + idStr}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	if !user.Active {
+		text += "Неактивен с " + user.DeactivatedAt.Format("02.01.2006") + ": " + html.EscapeString(user.DeactivationReason) + "\n"
+	}
+	text += "\n" + statusText(user) + "\n"
+
+	var settings []string
+	addList := func(name string, list []string) {
+		if len(list) > 0 {
+			settings = append(settings, name+": "+html.EscapeString(strings.Join(list, ", ")))
+		}
+	}
+	addList("Фильтры", user.Filters)
+	addList("Ключевые слова", user.Keywords)
+	addList("Регулярные выражения", user.Regexps)
+	addList("Подписки на авторов", user.FollowedAuthors)
+	addList("Заблокированные авторы", user.BlockedAuthors)
+	addList("Подписки на компании", user.FollowedCompanies)
+	addList("Заблокированные компании", user.BlockedCompanies)
+	addList("Ленты", user.Feeds)
+
+	settings = append(settings, "Доставка: "+deliveryNames[user.Delivery])
+	if user.Timezone != "" {
+		settings = append(settings, "Часовой пояс: "+html.EscapeString(user.Timezone))
+	}
+	if user.Quiet != "" {
+		settings = append(settings, "Тихие часы: "+html.EscapeString(user.Quiet+" "+user.QuietMode))
+	}
+	if opts, ok := userBestOptions(user); ok {
+		settings = append(settings, "Лучшие статьи: "+opts.String())
+	} else {
+		settings = append(settings, "Лучшие статьи: выключены")
+	}
+	if user.RemindAfter > 0 {
+		settings = append(settings, "Напоминания о закладках: через "+strconv.Itoa(user.RemindAfter)+" дн.")
+	}
+	text += "\n" + strings.Join(settings, "\n")
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	message.ParseMode = "HTML"
+	message.DisableWebPagePreview = true
+	bot.messages <- message
+}
+
+// Рассылки, ожидающие подтверждения: id -> текст
+var broadcasts = struct {
+	sync.Mutex
+	texts   map[string]string
+	created map[string]time.Time
+}{texts: make(map[string]string), created: make(map[string]time.Time)}
+
+// broadcast показывает администратору, как будет выглядеть сообщение, и просит подтвердить рассылку
+// (пример: /broadcast <b>Новая версия</b> бота). Текст может содержать HTML-разметку
+func (bot *Bot) broadcast(msg *tgbotapi.Message) {
+	if !bot.checkAdmin(msg) {
+		return
+	}
+
+	text := strings.TrimSpace(msg.CommandArguments())
+	if text == "" {
+		bot.sendErrorToUser("текст рассылки не может быть пустым (пример: /broadcast Текст сообщения)", msg.Chat.ID)
+		return
+	}
+
+	users, err := userdb.GetActiveUsers()
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/broadcast",
+			AddInfo:  "попытка получить список пользователей"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		logging.LogMinorError("broadcast", "попытка создать id рассылки", err)
+		return
+	}
+	id := hex.EncodeToString(buf)
+
+	// Предпросмотр отправляется сразу, чтобы сообщить администратору об ошибке в разметке
+	preview := tgbotapi.NewMessage(msg.Chat.ID, text)
+	preview.ParseMode = "HTML"
+	preview.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("📢 Отправить (%d)", len(users)), callbackBroadcast+id),
+		tgbotapi.NewInlineKeyboardButtonData("Отмена", callbackBroadcast+"cancel:"+id),
+	))
+	if _, err := bot.botAPI.Send(preview); err != nil {
+		bot.sendErrorToUser("не удалось показать сообщение: "+err.Error(), msg.Chat.ID)
+		return
+	}
+
+	broadcasts.Lock()
+	for key, created := range broadcasts.created {
+		if time.Since(created) > broadcastTTL {
+			delete(broadcasts.texts, key)
+			delete(broadcasts.created, key)
+		}
+	}
+	broadcasts.texts[id] = text
+	broadcasts.created[id] = time.Now()
+	broadcasts.Unlock()
+}
+
+// broadcastFromCallback ставит подтверждённую рассылку в очередь или отменяет её (broadcast:<id>, broadcast:cancel:<id>)
+func (bot *Bot) broadcastFromCallback(query *tgbotapi.CallbackQuery, data string) {
+	if !isAdmin(int64(query.From.ID)) {
+		bot.answerCallback(query, "Только для администраторов")
+		return
+	}
+
+	cancel := strings.HasPrefix(data, "cancel:")
+	id := strings.TrimPrefix(data, "cancel:")
+
+	broadcasts.Lock()
+	text, ok := broadcasts.texts[id]
+	delete(broadcasts.texts, id)
+	delete(broadcasts.created, id)
+	broadcasts.Unlock()
+
+	// Убираем кнопки, чтобы рассылку нельзя было отправить повторно. Пустой список строк задаётся явно:
+	// NewInlineKeyboardMarkup() без аргументов сериализуется в "inline_keyboard":null, и Telegram отклоняет запрос
+	noButtons := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	bot.editReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, noButtons)
+
+	if !ok {
+		bot.answerCallback(query, "Рассылка уже отправлена, отменена или устарела")
+		return
+	}
+	if cancel {
+		bot.answerCallback(query, "Рассылка отменена")
+		return
+	}
+
+	users, err := userdb.GetActiveUsers()
+	if err != nil {
+		logging.LogMinorError("broadcastFromCallback", "попытка получить список пользователей", err)
+		bot.answerCallback(query, "Что-то пошло не так")
+		return
+	}
+	bot.answerCallback(query, fmt.Sprintf("Рассылка поставлена в очередь: %d", len(users)))
+	logging.LogInfo("Рассылка %s: пользователей: %d, By: %d", id, len(users), query.From.ID)
+
+	for _, user := range users {
+		message := tgbotapi.NewMessage(user.ID, text)
+		message.ParseMode = "HTML"
+		bot.enqueue(message, "broadcast:"+id)
+	}
+}
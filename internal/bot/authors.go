@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+// Регулярные выражения для получения имени пользователя и компании из ссылок на Habr
+var (
+	habrUserLinkRegexp    = regexp.MustCompile(`(?:habrahabr\.ru|habr\.com|habr\.ru)/(?:\w+/)?users/([\w-]+)`)
+	habrCompanyLinkRegexp = regexp.MustCompile(`(?:habrahabr\.ru|habr\.com|habr\.ru)/(?:\w+/)?compan(?:y|ies)/([\w-]+)`)
+)
+
+// normalizeAuthor приводит автора ("@user", "User" или ссылка на профиль) к виду "user"
+func normalizeAuthor(s string) string {
+	if match := habrUserLinkRegexp.FindStringSubmatch(s); match != nil {
+		s = match[1]
+	}
+	return strings.ToLower(strings.TrimPrefix(s, "@"))
+}
+
+// normalizeCompany приводит компанию ("Yandex" или ссылка на блог компании) к виду "yandex"
+func normalizeCompany(s string) string {
+	if match := habrCompanyLinkRegexp.FindStringSubmatch(s); match != nil {
+		s = match[1]
+	}
+	return strings.ToLower(s)
+}
+
+// getCompany возвращает компанию, в блоге которой опубликована статья. Если статья не из блога компании – ""
+func getCompany(link string) string {
+	if match := habrCompanyLinkRegexp.FindStringSubmatch(link); match != nil {
+		return strings.ToLower(match[1])
+	}
+	return ""
+}
+
+func contains(slice []string, s string) bool {
+	for _, elem := range slice {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlocked проверяет, заблокировал ли пользователь автора или компанию статьи
+func isBlocked(user userdb.User, a article) bool {
+	return (a.author != "" && contains(user.BlockedAuthors, a.author)) ||
+		(a.company != "" && contains(user.BlockedCompanies, a.company))
+}
+
+// isFollowed проверяет, подписан ли пользователь на автора или компанию статьи
+func isFollowed(user userdb.User, a article) bool {
+	return (a.author != "" && contains(user.FollowedAuthors, a.author)) ||
+		(a.company != "" && contains(user.FollowedCompanies, a.company))
+}
+
+// authorsText возвращает списки авторов и компаний в виде текста
+func authorsText(user userdb.User) string {
+	list := func(title string, items []string) string {
+		if len(items) == 0 {
+			return title + ": –\n"
+		}
+		return title + ":\n* " + strings.Join(items, "\n* ") + "\n"
+	}
+
+	return list("👍 Авторы", user.FollowedAuthors) +
+		list("🚫 Заблокированные авторы", user.BlockedAuthors) +
+		list("👍 Компании", user.FollowedCompanies) +
+		list("🚫 Заблокированные компании", user.BlockedCompanies)
+}
+
+// updateAuthors добавляет авторов или компании из аргументов команды в список addField и удаляет их из delFields.
+// Если addField пустой, элементы только удаляются
+func (bot *Bot) updateAuthors(msg *tgbotapi.Message, normalize func(string) string, addField string, delFields ...string) {
+	var items []string
+	for _, s := range strings.Fields(msg.CommandArguments()) {
+		items = append(items, normalize(s))
+	}
+	items = toSet(items)
+	if len(items) == 0 {
+		bot.sendErrorToUser("список не может быть пустым", msg.Chat.ID)
+		return
+	}
+
+	id := strconv.FormatInt(msg.Chat.ID, 10)
+	var err error
+	if addField != "" {
+		_, err = userdb.UpdateUserList(id, addField, items, nil)
+	}
+	for _, field := range delFields {
+		if err != nil {
+			break
+		}
+		_, err = userdb.UpdateUserList(id, field, nil, items)
+	}
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/..." + msg.Command(),
+			AddInfo:  "попытка обновить список авторов или компаний"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	bot.getAuthors(msg)
+}
+
+// followAuthor подписывает пользователя на все статьи авторов (пример: /follow_author @user)
+func (bot *Bot) followAuthor(msg *tgbotapi.Message) {
+	bot.updateAuthors(msg, normalizeAuthor, userdb.FollowedAuthorsField, userdb.BlockedAuthorsField)
+}
+
+// blockAuthor блокирует все статьи авторов
+func (bot *Bot) blockAuthor(msg *tgbotapi.Message) {
+	bot.updateAuthors(msg, normalizeAuthor, userdb.BlockedAuthorsField, userdb.FollowedAuthorsField)
+}
+
+// followCompany подписывает пользователя на все статьи из блогов компаний (пример: /follow_company yandex)
+func (bot *Bot) followCompany(msg *tgbotapi.Message) {
+	bot.updateAuthors(msg, normalizeCompany, userdb.FollowedCompaniesField, userdb.BlockedCompaniesField)
+}
+
+// blockCompany блокирует все статьи из блогов компаний
+func (bot *Bot) blockCompany(msg *tgbotapi.Message) {
+	bot.updateAuthors(msg, normalizeCompany, userdb.BlockedCompaniesField, userdb.FollowedCompaniesField)
+}
+
+// forgetAuthor удаляет авторов из списков подписок и блокировок
+func (bot *Bot) forgetAuthor(msg *tgbotapi.Message) {
+	bot.updateAuthors(msg, normalizeAuthor, "", userdb.FollowedAuthorsField, userdb.BlockedAuthorsField)
+}
+
+// forgetCompany удаляет компании из списков подписок и блокировок
+func (bot *Bot) forgetCompany(msg *tgbotapi.Message) {
+	bot.updateAuthors(msg, normalizeCompany, "", userdb.FollowedCompaniesField, userdb.BlockedCompaniesField)
+}
+
+// getAuthors отправляет пользователю списки авторов и компаний
+func (bot *Bot) getAuthors(msg *tgbotapi.Message) {
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/..." + msg.Command(),
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, authorsText(user))
+	bot.messages <- message
+}
@@ -0,0 +1,60 @@
+package bot
+
+import "testing"
+
+func TestGetCompany(t *testing.T) {
+	tests := []struct {
+		link    string
+		company string
+	}{
+		// Ссылки из RSS-лент Habr
+		{"https://habr.com/ru/companies/yandex/articles/765432/?utm_source=habrahabr&utm_medium=rss&utm_campaign=765432", "yandex"},
+		{"https://habr.com/en/companies/ruvds/articles/773090/", "ruvds"},
+		{"https://habr.com/ru/companies/sberbank/news/766106/", "sberbank"},
+		{"https://habr.com/ru/companies/otus/articles/765940/", "otus"},
+		{"https://habr.com/ru/companies/Positive_Technologies/articles/765000/", "positive_technologies"},
+		// Старый формат ссылок
+		{"https://habr.com/ru/company/mailru/blog/452124/", "mailru"},
+		{"https://habr.com/company/tinkoff/blog/452210/", "tinkoff"},
+		{"https://habrahabr.ru/company/jugru/blog/333180/", "jugru"},
+		// Статьи не из блога компании
+		{"https://habr.com/ru/articles/765432/?utm_source=habrahabr&utm_medium=rss&utm_campaign=765432", ""},
+		{"https://habr.com/ru/post/452100/", ""},
+		{"https://habr.com/ru/news/766100/", ""},
+		{"https://example.com/companies/yandex/articles/1/", ""},
+	}
+
+	for _, tt := range tests {
+		if got := getCompany(tt.link); got != tt.company {
+			t.Errorf("getCompany(%q) = %q, want %q", tt.link, got, tt.company)
+		}
+	}
+}
+
+func TestNormalizeAuthor(t *testing.T) {
+	tests := []struct {
+		author string
+		want   string
+	}{
+		// Автор в RSS-ленте
+		{"Tirsias", "tirsias"},
+		{"deniskin_v", "deniskin_v"},
+		{"Mr-Dark", "mr-dark"},
+		// Аргументы команд
+		{"@Tirsias", "tirsias"},
+		{"https://habr.com/ru/users/Tirsias/", "tirsias"},
+		{"https://habr.com/ru/users/tirsias/posts/", "tirsias"},
+		{"https://habr.com/users/tirsias/", "tirsias"},
+		{"https://habrahabr.ru/users/kirtis/", "kirtis"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeAuthor(tt.author); got != tt.want {
+			t.Errorf("normalizeAuthor(%q) = %q, want %q", tt.author, got, tt.want)
+		}
+	}
+
+	if got := normalizeCompany("https://habr.com/ru/companies/Yandex/articles/"); got != "yandex" {
+		t.Errorf("normalizeCompany: got %q, want yandex", got)
+	}
+}
@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/anaskhan96/soup"
+	"github.com/mmcdole/gofeed"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+// Backfiller – источник, который умеет получать статьи, пропавшие из ленты между опросами
+// Реализация необязательна: если источник её не поддерживает, пропущенные статьи не восстанавливаются
+type Backfiller interface {
+	// Backfill возвращает статьи, опубликованные после последней обработанной (новые раньше).
+	// isSeen проверяет, была ли статья уже обработана
+	Backfill(isSeen func(link string) bool) ([]*gofeed.Item, error)
+}
+
+// Максимальное количество страниц, которые просматриваются при восстановлении пропущенных статей
+const maxBackfillPages = 10
+
+// Статистика восстановления пропущенных статей
+var (
+	// количество опросов источников с Backfiller, в которых не нашлось ни одной уже обработанной статьи
+	feedGaps uint64
+	// количество восстановленных статей
+	recoveredArticles uint64
+)
+
+// habrSource – источник статей Habr. Кроме RSS-ленты умеет просматривать страницы со списком статей
+type habrSource struct {
+	rssSource
+	// адрес страницы со списком статей. Нужно отформатировать функцией fmt.Sprintf(listingURL, page)
+	listingURL string
+}
+
+// Backfill просматривает страницы со списком статей, пока не найдёт уже обработанную статью
+func (s *habrSource) Backfill(isSeen func(link string) bool) ([]*gofeed.Item, error) {
+	var items []*gofeed.Item
+
+	for page := 1; page <= maxBackfillPages; page++ {
+		pageItems, err := getHabrListing(fmt.Sprintf(s.listingURL, page))
+		if err != nil {
+			return items, err
+		}
+		if len(pageItems) == 0 {
+			break
+		}
+
+		for _, item := range pageItems {
+			if isSeen(item.Link) {
+				return items, nil
+			}
+			items = append(items, item)
+		}
+	}
+
+	logging.LogInfo("Источник %s: не удалось найти обработанную статью на %d страницах", s.name, maxBackfillPages)
+	return items, nil
+}
+
+// getHabrListing загружает страницу со списком статей Habr и возвращает статьи в виде записей ленты
+func getHabrListing(url string) ([]*gofeed.Item, error) {
+	page, err := fetchPage(url)
+	if err != nil {
+		return nil, err
+	}
+	return parseHabrListing(page), nil
+}
+
+// parseHabrListing возвращает статьи со страницы со списком статей Habr
+func parseHabrListing(page string) []*gofeed.Item {
+	var items []*gofeed.Item
+
+	doc := soup.HTMLParse(page)
+	for _, node := range doc.FindAll("article", "class", "tm-articles-list__item") {
+		titleNode := node.Find("a", "class", "tm-title__link")
+		if titleNode.Error != nil {
+			continue
+		}
+
+		item := &gofeed.Item{
+			Title: strings.TrimSpace(titleNode.FullText()),
+			Link:  absoluteHabrURL(titleNode.Attrs()["href"]),
+		}
+
+		if timeNode := node.Find("time"); timeNode.Error == nil {
+			if t, err := time.Parse(time.RFC3339, timeNode.Attrs()["datetime"]); err == nil {
+				item.PublishedParsed = &t
+			}
+		}
+
+		if authorNode := node.Find("a", "class", "tm-user-info__username"); authorNode.Error == nil {
+			item.Author = &gofeed.Person{Name: strings.TrimSpace(authorNode.FullText())}
+		}
+
+		for _, hubNode := range node.FindAll("a", "class", "tm-publication-hub__link") {
+			if span := hubNode.Find("span"); span.Error == nil {
+				item.Categories = append(item.Categories, strings.TrimSpace(span.Text()))
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// absoluteHabrURL превращает относительную ссылку Habr в абсолютную
+func absoluteHabrURL(href string) string {
+	if strings.HasPrefix(href, "/") {
+		return "https://habr.com" + href
+	}
+	return href
+}
+
+// isGap проверяет, пропали ли статьи между опросами: ни одна запись ленты не была обработана раньше
+func isGap(items, newItems []*gofeed.Item) bool {
+	return len(items) > 0 && len(newItems) == len(items)
+}
+
+// backfill дополняет новые записи источника name статьями, пропавшими из ленты между опросами
+func backfill(name string, b Backfiller, newItems []*gofeed.Item) []*gofeed.Item {
+	isSeen := func(link string) bool {
+		key := articleKey(link)
+		seen, _, err := userdb.GetSeen(name, []string{key})
+		if err != nil {
+			// Лучше прекратить восстановление, чем прислать много старых статей
+			return true
+		}
+		return seen[key]
+	}
+
+	recovered, err := b.Backfill(isSeen)
+	if err != nil {
+		logging.LogMinorError("backfill", "попытка восстановить пропущенные статьи источника "+name, err)
+	}
+
+	known := make(map[string]bool)
+	for _, item := range newItems {
+		known[articleKey(item.Link)] = true
+	}
+
+	var counter int
+	for _, item := range recovered {
+		key := articleKey(item.Link)
+		if known[key] {
+			continue
+		}
+		known[key] = true
+		newItems = append(newItems, item)
+		counter++
+	}
+	sortItems(newItems)
+
+	atomic.AddUint64(&recoveredArticles, uint64(counter))
+	logging.LogInfo("Источник %s: восстановлено пропущенных статей: %d", name, counter)
+
+	return newItems
+}
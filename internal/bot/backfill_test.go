@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+func TestIsGap(t *testing.T) {
+	a, b := &gofeed.Item{Link: "a"}, &gofeed.Item{Link: "b"}
+
+	tests := []struct {
+		name            string
+		items, newItems []*gofeed.Item
+		gap             bool
+	}{
+		{"empty feed", nil, nil, false},
+		{"nothing new", []*gofeed.Item{a, b}, nil, false},
+		{"some new", []*gofeed.Item{a, b}, []*gofeed.Item{a}, false},
+		{"all new", []*gofeed.Item{a, b}, []*gofeed.Item{a, b}, true},
+	}
+
+	for _, tt := range tests {
+		if got := isGap(tt.items, tt.newItems); got != tt.gap {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.gap)
+		}
+	}
+}
+
+func TestParseHabrListing(t *testing.T) {
+	page, err := ioutil.ReadFile("testdata/habr_listing.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := parseHabrListing(string(page))
+	if len(items) != 3 {
+		t.Fatalf("expected 3 articles, got %d", len(items))
+	}
+
+	first := items[0]
+	if first.Title != "Профилирование Go-сервисов в продакшене" {
+		t.Errorf("title: got %q", first.Title)
+	}
+	if first.Link != "https://habr.com/ru/articles/765432/" {
+		t.Errorf("link: got %q", first.Link)
+	}
+	if first.Author == nil || first.Author.Name != "gopher" {
+		t.Errorf("author: got %+v", first.Author)
+	}
+	published := time.Date(2023, 10, 2, 9, 15, 0, 0, time.UTC)
+	if first.PublishedParsed == nil || !first.PublishedParsed.Equal(published) {
+		t.Errorf("published: got %v, want %v", first.PublishedParsed, published)
+	}
+	if want := []string{"Go", "Высокая производительность"}; !reflect.DeepEqual(first.Categories, want) {
+		t.Errorf("hubs: got %q, want %q", first.Categories, want)
+	}
+
+	second := items[1]
+	if second.Title != "Индексы в PostgreSQL & MySQL" {
+		t.Errorf("html entities must be decoded: got %q", second.Title)
+	}
+	if second.Link != "https://habr.com/ru/companies/yandex/articles/765431/" || getCompany(second.Link) != "yandex" {
+		t.Errorf("company link: got %q", second.Link)
+	}
+
+	// Статья без автора и с некорректной датой
+	third := items[2]
+	if third.Link != "https://habr.com/ru/news/765430/" {
+		t.Errorf("absolute link must not change: got %q", third.Link)
+	}
+	if third.Author != nil || third.PublishedParsed != nil || len(third.Categories) != 0 {
+		t.Errorf("unexpected fields: %+v", third)
+	}
+
+	// Ключи статей совпадают с ключами статей из RSS-ленты
+	for i, want := range []string{"765432", "765431", "765430"} {
+		if key := articleKey(items[i].Link); key != want {
+			t.Errorf("article key of %s: got %q, want %q", items[i].Link, key, want)
+		}
+	}
+	if key := articleKey("https://habr.com/ru/articles/765432/?utm_source=habrahabr&utm_medium=rss"); key != "765432" {
+		t.Errorf("article key of the rss link: got %q", key)
+	}
+}
+
+// testSource – источник, записи которого задаются в тесте
+type testSource struct {
+	name  string
+	items []*gofeed.Item
+}
+
+func (s *testSource) Name() string                        { return s.name }
+func (s *testSource) Interval() time.Duration             { return time.Minute }
+func (s *testSource) Fetch() ([]*gofeed.Item, error)      { return s.items, nil }
+func (s *testSource) Normalize(item *gofeed.Item) article { return article{link: item.Link} }
+
+func (s *testSource) setItems(links ...string) {
+	s.items = nil
+	for _, link := range links {
+		s.items = append(s.items, &gofeed.Item{Link: link})
+	}
+}
+
+// testBackfillSource – источник, умеющий восстанавливать пропущенные статьи
+type testBackfillSource struct {
+	testSource
+	calls int
+}
+
+func (s *testBackfillSource) Backfill(isSeen func(link string) bool) ([]*gofeed.Item, error) {
+	s.calls++
+	return nil, nil
+}
+
+func TestFetchNewGaps(t *testing.T) {
+	if err := userdb.Open(filepath.Join(t.TempDir(), "users.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer userdb.Close()
+
+	var r sourceRegistry
+	articles := make(chan article, 10)
+	fetch := func(src Source) int {
+		r.fetchNew(src, articles, nil)
+		n := len(articles)
+		for len(articles) > 0 {
+			<-articles
+		}
+		return n
+	}
+
+	feed := &testSource{name: "user-feed"}
+	habr := &testBackfillSource{testSource: testSource{name: "habr"}}
+	feed.setItems("https://example.com/1", "https://example.com/2")
+	habr.setItems("https://example.com/1", "https://example.com/2")
+
+	// При первом опросе все записи считаются старыми
+	if n := fetch(feed) + fetch(habr); n != 0 {
+		t.Fatalf("first fetch: got %d articles", n)
+	}
+
+	gaps := atomic.LoadUint64(&feedGaps)
+
+	// Пользовательская лента целиком обновилась – это не пропуск
+	feed.setItems("https://example.com/3", "https://example.com/4")
+	if n := fetch(feed); n != 2 {
+		t.Errorf("user feed: got %d articles, want 2", n)
+	}
+	if got := atomic.LoadUint64(&feedGaps); got != gaps {
+		t.Errorf("user feed must not be checked for gaps: %d gaps", got-gaps)
+	}
+
+	habr.setItems("https://example.com/3", "https://example.com/4")
+	if n := fetch(habr); n != 2 {
+		t.Errorf("habr: got %d articles, want 2", n)
+	}
+	if got := atomic.LoadUint64(&feedGaps); got != gaps+1 || habr.calls != 1 {
+		t.Errorf("habr: got %d gaps and %d backfills, want 1 and 1", got-gaps, habr.calls)
+	}
+}
@@ -0,0 +1,305 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+const (
+	// Количество статей в /best по-умолчанию
+	defaultBestLimit = 5
+	// Количество статей в ежедневной рассылке лучших статей по-умолчанию
+	defaultBestMailoutLimit = 7
+	// Максимальное количество статей в списке лучших статей
+	maxBestLimit = 30
+
+	// Отключение ежедневной рассылки лучших статей
+	bestOff = "off"
+)
+
+// Периоды лучших статей: название для пользователя -> период в адресе RSS-ленты
+var bestPeriods = map[string]string{
+	"day":   "daily",
+	"week":  "weekly",
+	"month": "monthly",
+	"year":  "yearly",
+}
+
+// Названия периодов для заголовка списка
+var bestPeriodNames = map[string]string{
+	"day":   "за день",
+	"week":  "за неделю",
+	"month": "за месяц",
+	"year":  "за год",
+}
+
+// bestOptions – параметры списка лучших статей
+type bestOptions struct {
+	// day, week, month или year
+	period string
+	// ru, en или all
+	lang string
+	// оставить только статьи с тегами пользователя
+	mine  bool
+	limit int
+}
+
+// parseBestOptions разбирает аргументы (например, "week en mine 10"). Порядок аргументов не важен
+func parseBestOptions(args string, limit int) (bestOptions, error) {
+	opts := bestOptions{period: "day", lang: "ru", limit: limit}
+
+	for _, arg := range strings.Fields(strings.ToLower(args)) {
+		switch {
+		case bestPeriods[arg] != "":
+			opts.period = arg
+		case arg == "ru" || arg == "en" || arg == "all":
+			opts.lang = arg
+		case arg == "mine":
+			opts.mine = true
+		default:
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > maxBestLimit {
+				return opts, errors.New("неизвестный аргумент '" + arg + "'. Доступные аргументы: day, week, month, year, ru, en, all, mine и количество статей (от 1 до " + strconv.Itoa(maxBestLimit) + ")")
+			}
+			opts.limit = n
+		}
+	}
+
+	return opts, nil
+}
+
+// String возвращает параметры в виде аргументов команды
+func (opts bestOptions) String() string {
+	s := opts.period + " " + opts.lang
+	if opts.mine {
+		s += " mine"
+	}
+	return s + " " + strconv.Itoa(opts.limit)
+}
+
+// bestFeeds кэширует RSS-ленты лучших статей на время одной рассылки, чтобы не загружать одну ленту много раз
+type bestFeeds map[string][]*gofeed.Item
+
+// get возвращает записи ленты лучших статей для языка lang ("ru" или "en") и периода period
+func (feeds bestFeeds) get(lang, period string) ([]*gofeed.Item, error) {
+	url := fmt.Sprintf(bestHabrArticlesURL, lang, bestPeriods[period])
+	if items, ok := feeds[url]; ok {
+		return items, nil
+	}
+
+	feed, err := getRSS(url)
+	if err != nil {
+		return nil, err
+	}
+	feeds[url] = feed.Items
+	return feed.Items, nil
+}
+
+// getBestArticles возвращает лучшие статьи. Для языка "all" статьи из русской и английской лент чередуются
+func getBestArticles(feeds bestFeeds, opts bestOptions, userTags []string) ([]*gofeed.Item, error) {
+	var items []*gofeed.Item
+	if opts.lang == "all" {
+		ru, err := feeds.get("ru", opts.period)
+		if err != nil {
+			return nil, err
+		}
+		en, err := feeds.get("en", opts.period)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(ru) || i < len(en); i++ {
+			if i < len(ru) {
+				items = append(items, ru[i])
+			}
+			if i < len(en) {
+				items = append(items, en[i])
+			}
+		}
+	} else {
+		var err error
+		items, err = feeds.get(opts.lang, opts.period)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var result []*gofeed.Item
+	for _, item := range items {
+		if len(result) == opts.limit {
+			break
+		}
+		if opts.mine && !hasUserTag(item, userTags) {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// hasUserTag проверяет, есть ли у записи хотя бы один тег пользователя
+func hasUserTag(item *gofeed.Item, userTags []string) bool {
+	for _, category := range item.Categories {
+		if contains(userTags, normalizeTag(category)) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatBest возвращает список лучших статей в виде текста
+func formatBest(opts bestOptions, items []*gofeed.Item) string {
+	text := "<b>Лучшие статьи " + bestPeriodNames[opts.period]
+	if opts.mine {
+		text += " по вашим тегам"
+	}
+	text += ":</b>\n"
+
+	if len(items) == 0 {
+		return text + "Статей не найдено"
+	}
+
+	for i, item := range items {
+		text += strconv.Itoa(i+1) + ") " + formatString("<a href='{link}'>{title}</a>",
+			map[string]string{"link": item.Link, "title": html.EscapeString(item.Title)}) + "\n"
+	}
+	return text
+}
+
+// bestKeyboard возвращает кнопки сохранения статей из списка лучших статей в закладки
+func bestKeyboard(items []*gofeed.Item) interface{} {
+	links := make([]string, 0, len(items))
+	for _, item := range items {
+		links = append(links, item.Link)
+	}
+	return listKeyboard(links)
+}
+
+// userBestOptions возвращает параметры ежедневной рассылки лучших статей пользователя.
+// Второе возвращаемое значение – false, если рассылка выключена
+func userBestOptions(user userdb.User) (bestOptions, bool) {
+	if user.Best == bestOff {
+		return bestOptions{}, false
+	}
+
+	opts, err := parseBestOptions(user.Best, defaultBestMailoutLimit)
+	if err != nil {
+		opts, _ = parseBestOptions("", defaultBestMailoutLimit)
+	}
+	return opts, true
+}
+
+// getBest отправляет пользователю лучшие статьи (пример: /best week en mine 10).
+// По-умолчанию – 5 лучших статей на русском за день
+func (bot *Bot) getBest(msg *tgbotapi.Message) {
+	opts, err := parseBestOptions(msg.CommandArguments(), defaultBestLimit)
+	if err != nil {
+		bot.sendErrorToUser(err.Error(), msg.Chat.ID)
+		return
+	}
+
+	var userTags []string
+	if opts.mine {
+		user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+		if err != nil {
+			data := logging.ErrorData{
+				Error:    err,
+				Username: msg.Chat.UserName,
+				UserID:   msg.Chat.ID,
+				Command:  "/...best",
+				AddInfo:  "попытка получить данные пользователя"}
+			bot.logErrorAndNotify(data)
+			return
+		}
+		if len(user.Tags) == 0 {
+			bot.sendErrorToUser("список тегов пуст, поэтому mine не найдёт ни одной статьи", msg.Chat.ID)
+			return
+		}
+		userTags = user.Tags
+	}
+
+	items, err := getBestArticles(bestFeeds{}, opts, userTags)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...best",
+			AddInfo:  "попытка распарсить RSS-ленту"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, formatBest(opts, items))
+	message.ParseMode = "HTML"
+	message.DisableWebPagePreview = true
+	if len(items) > 0 {
+		message.ReplyMarkup = bestKeyboard(items)
+	}
+	bot.messages <- message
+}
+
+// setBestMailout настраивает ежедневную рассылку лучших статей (пример: /best_mailout week mine 10, /best_mailout off)
+// Без аргументов показывает текущие настройки
+func (bot *Bot) setBestMailout(msg *tgbotapi.Message) {
+	args := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	id := strconv.FormatInt(msg.Chat.ID, 10)
+
+	if args == "" {
+		user, err := userdb.GetUser(id)
+		if err != nil {
+			data := logging.ErrorData{
+				Error:    err,
+				Username: msg.Chat.UserName,
+				UserID:   msg.Chat.ID,
+				Command:  "/...best_mailout",
+				AddInfo:  "попытка получить данные пользователя"}
+			bot.logErrorAndNotify(data)
+			return
+		}
+
+		text := "Ежедневная рассылка лучших статей выключена"
+		if opts, ok := userBestOptions(user); ok {
+			text = "Ежедневная рассылка лучших статей (в " + strconv.Itoa(bestArticlesHour) + ":00): " + opts.String()
+		}
+		text += "\n\nПример: /best_mailout week en mine 10, /best_mailout off"
+		bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, text)
+		return
+	}
+
+	value := bestOff
+	if args != bestOff {
+		opts, err := parseBestOptions(args, defaultBestMailoutLimit)
+		if err != nil {
+			bot.sendErrorToUser(err.Error(), msg.Chat.ID)
+			return
+		}
+		value = opts.String()
+	}
+
+	err := userdb.SetBest(id, value)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...best_mailout",
+			AddInfo:  "попытка изменить рассылку лучших статей"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	text := "Ежедневная рассылка лучших статей выключена"
+	if value != bestOff {
+		text = "Ежедневная рассылка лучших статей: " + value
+	}
+	bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, text)
+}
@@ -0,0 +1,257 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+const (
+	// Количество закладок на одной странице /saved
+	bookmarksPerPage = 10
+	// Максимальное количество дней для напоминания о закладках
+	maxRemindAfter = 365
+)
+
+// bookmarkKey возвращает ключ закладки: номер поста для статей Habr, для остальных – хеш ссылки
+// (ключ передаётся в данных кнопки, длина которых ограничена 64 байтами)
+func bookmarkKey(link string) string {
+	if key := articleKey(link); key != link {
+		return key
+	}
+	sum := sha256.Sum256([]byte(link))
+	return "h" + hex.EncodeToString(sum[:8])
+}
+
+// messageLink возвращает ссылку из сообщения, ключ закладки которой равен key. Если такой ссылки нет – ""
+func messageLink(msg *tgbotapi.Message, key string) string {
+	if msg.Entities == nil {
+		return ""
+	}
+	for _, entity := range *msg.Entities {
+		if entity.Type == "text_link" && bookmarkKey(entity.URL) == key {
+			return entity.URL
+		}
+	}
+	return ""
+}
+
+// bookmarksPage возвращает текст страницы page (с 0) списка закладок и кнопки для перехода между страницами
+func bookmarksPage(bookmarks []userdb.Bookmark, page int) (string, *tgbotapi.InlineKeyboardMarkup) {
+	if len(bookmarks) == 0 {
+		return "Список закладок пуст. Чтобы сохранить статью, нажмите 🔖 под ней", nil
+	}
+
+	pages := (len(bookmarks) + bookmarksPerPage - 1) / bookmarksPerPage
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	text := "<b>🔖 Закладки (" + strconv.Itoa(len(bookmarks)) + "):</b>\n"
+	for i := page * bookmarksPerPage; i < len(bookmarks) && i < (page+1)*bookmarksPerPage; i++ {
+		b := bookmarks[i]
+		text += strconv.Itoa(i+1) + ") " + formatString("<a href='{link}'>{title}</a>",
+			map[string]string{"link": b.Link, "title": html.EscapeString(b.Title)}) + "\n"
+	}
+	text += "\nУдалить закладку: /unsave номер"
+
+	if pages == 1 {
+		return text, nil
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("⬅️", callbackSaved+strconv.Itoa(page-1)))
+	}
+	row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", page+1, pages), callbackSaved+strconv.Itoa(page)))
+	if page < pages-1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("➡️", callbackSaved+strconv.Itoa(page+1)))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(row)
+
+	return text, &markup
+}
+
+// getBookmarks отправляет пользователю список закладок (пример: /saved 2 – вторая страница)
+func (bot *Bot) getBookmarks(msg *tgbotapi.Message) {
+	page := 1
+	if arg := strings.TrimSpace(msg.CommandArguments()); arg != "" {
+		var err error
+		page, err = strconv.Atoi(arg)
+		if err != nil || page < 1 {
+			bot.sendErrorToUser("номер страницы должен быть положительным числом", msg.Chat.ID)
+			return
+		}
+	}
+
+	bookmarks, err := userdb.GetBookmarks(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...saved",
+			AddInfo:  "попытка получить закладки"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	text, markup := bookmarksPage(bookmarks, page-1)
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	message.ParseMode = "HTML"
+	message.DisableWebPagePreview = true
+	if markup != nil {
+		message.ReplyMarkup = markup
+	}
+	bot.messages <- message
+}
+
+// unsave удаляет закладку по номеру из списка /saved или по ссылке на статью (пример: /unsave 1)
+func (bot *Bot) unsave(msg *tgbotapi.Message) {
+	arg := strings.TrimSpace(msg.CommandArguments())
+	id := strconv.FormatInt(msg.Chat.ID, 10)
+
+	postID := getPostID(arg)
+	if postID == "" && strings.HasPrefix(arg, "http") {
+		postID = bookmarkKey(arg)
+	}
+	if postID == "" {
+		number, err := strconv.Atoi(arg)
+		if err != nil || number < 1 {
+			bot.sendErrorToUser("нужно указать номер закладки из списка /saved или ссылку на статью", msg.Chat.ID)
+			return
+		}
+
+		bookmarks, err := userdb.GetBookmarks(id)
+		if err != nil {
+			data := logging.ErrorData{
+				Error:    err,
+				Username: msg.Chat.UserName,
+				UserID:   msg.Chat.ID,
+				Command:  "/...unsave",
+				AddInfo:  "попытка получить закладки"}
+			bot.logErrorAndNotify(data)
+			return
+		}
+		if number > len(bookmarks) {
+			bot.sendErrorToUser("закладки с таким номером нет", msg.Chat.ID)
+			return
+		}
+		postID = bookmarks[number-1].PostID
+	}
+
+	deleted, err := userdb.DelBookmark(id, postID)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...unsave",
+			AddInfo:  "попытка удалить закладку"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+	if !deleted {
+		bot.sendErrorToUser("такой статьи нет в закладках", msg.Chat.ID)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, "Закладка удалена")
+	bot.messages <- message
+}
+
+// setRemind устанавливает, через сколько дней напоминать о закладках (пример: /remind 7, /remind off)
+func (bot *Bot) setRemind(msg *tgbotapi.Message) {
+	arg := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+
+	var days int
+	if arg != "off" {
+		var err error
+		days, err = strconv.Atoi(arg)
+		if err != nil || days < 1 || days > maxRemindAfter {
+			bot.sendErrorToUser("нужно указать количество дней (от 1 до "+strconv.Itoa(maxRemindAfter)+") или off", msg.Chat.ID)
+			return
+		}
+	}
+
+	err := userdb.SetRemindAfter(strconv.FormatInt(msg.Chat.ID, 10), days)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...remind",
+			AddInfo:  "попытка изменить напоминания"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	text := "Напоминания о закладках выключены"
+	if days > 0 {
+		text = "Бот напомнит о закладках, которые пролежали больше " + strconv.Itoa(days) + " дн."
+	}
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	bot.messages <- message
+}
+
+// remindBookmarks напоминает пользователям о закладках, которые лежат дольше, чем пользователь указал в /remind
+// О каждой закладке напоминается только один раз
+func (bot *Bot) remindBookmarks() {
+	users, err := userdb.GetActiveUsers()
+	if err != nil {
+		logging.LogMinorError("remindBookmarks", "попытка получить список пользователей", err)
+		return
+	}
+
+	now := time.Now()
+	for _, user := range users {
+		if user.RemindAfter == 0 || inQuietHours(user, now) {
+			continue
+		}
+
+		id := strconv.FormatInt(user.ID, 10)
+		bookmarks, err := userdb.GetBookmarks(id)
+		if err != nil {
+			logging.LogMinorError("remindBookmarks", "попытка получить закладки пользователя "+id, err)
+			continue
+		}
+
+		deadline := now.AddDate(0, 0, -user.RemindAfter)
+		var lines, postIDs []string
+		for _, b := range bookmarks {
+			if b.Reminded || b.SavedAt.After(deadline) {
+				continue
+			}
+			postIDs = append(postIDs, b.PostID)
+			lines = append(lines, formatLinkLine(len(postIDs), b.Title, b.Link))
+		}
+		if len(postIDs) == 0 {
+			continue
+		}
+
+		header := "<b>⏰ Вы сохранили эти статьи больше " + strconv.Itoa(user.RemindAfter) + " дн. назад:</b>\n"
+		for _, text := range splitMessage(header, lines) {
+			message := tgbotapi.NewMessage(user.ID, text)
+			message.ParseMode = "HTML"
+			message.DisableWebPagePreview = true
+			bot.enqueue(message, "")
+		}
+
+		err = userdb.MarkBookmarksReminded(id, postIDs)
+		if err != nil {
+			logging.LogMinorError("remindBookmarks", "попытка отметить закладки пользователя "+id, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+func TestBookmarkKey(t *testing.T) {
+	if key := bookmarkKey("https://habr.com/ru/articles/765432/?utm_source=habrahabr"); key != "765432" {
+		t.Errorf("habr article: got %q", key)
+	}
+
+	link := "https://example.com/blog/" + strings.Repeat("very-long-slug-", 10)
+	key := bookmarkKey(link)
+	if !strings.HasPrefix(key, "h") || len(callbackSave+key) > maxCallbackData {
+		t.Errorf("feed article: got %q", key)
+	}
+	if key != bookmarkKey(link) || key == bookmarkKey(link+"2") {
+		t.Error("key must depend only on the link")
+	}
+}
+
+func TestArticleKeyboard(t *testing.T) {
+	buttons := func(markup interface{}) []tgbotapi.InlineKeyboardButton {
+		keyboard, ok := markup.(tgbotapi.InlineKeyboardMarkup)
+		if !ok {
+			t.Fatalf("expected a keyboard, got %#v", markup)
+		}
+		var result []tgbotapi.InlineKeyboardButton
+		for _, row := range keyboard.InlineKeyboard {
+			result = append(result, row...)
+		}
+		return result
+	}
+
+	habr := buttons(articleKeyboard("https://habr.com/ru/articles/765432/", []string{"go", "linux"}, []string{"linux"}))
+	if len(habr) != 2 || *habr[0].CallbackData != "save:765432" || *habr[1].CallbackData != "tag:765432:go" {
+		t.Errorf("habr article: got %d buttons", len(habr))
+	}
+
+	link := "https://example.com/posts/1"
+	feed := buttons(articleKeyboard(link, []string{"go"}, nil))
+	if len(feed) != 1 || *feed[0].CallbackData != callbackSave+bookmarkKey(link) {
+		t.Errorf("feed article must have only the save button, got %d buttons", len(feed))
+	}
+
+	list := buttons(listKeyboard([]string{"https://habr.com/ru/articles/1/", "https://habr.com/ru/articles/2/"}))
+	if len(list) != 2 || list[1].Text != "🔖 2" || *list[1].CallbackData != "save:2" {
+		t.Errorf("list: got %d buttons", len(list))
+	}
+	if listKeyboard(nil) != nil {
+		t.Error("empty list must have no keyboard")
+	}
+}
+
+func TestMessageLink(t *testing.T) {
+	link := "https://example.com/posts/1"
+	msg := &tgbotapi.Message{
+		Text: "Заголовок\n\nОткрыть статью\n\nОткрыть комментарии",
+		Entities: &[]tgbotapi.MessageEntity{
+			{Type: "bold", Offset: 0, Length: 9},
+			{Type: "text_link", Offset: 11, Length: 14, URL: link},
+			{Type: "text_link", Offset: 27, Length: 19, URL: link + "#comments"},
+		},
+	}
+
+	if got := messageLink(msg, bookmarkKey(link)); got != link {
+		t.Errorf("got %q, want %q", got, link)
+	}
+	if got := messageLink(msg, bookmarkKey("https://example.com/posts/2")); got != "" {
+		t.Errorf("unknown key: got %q", got)
+	}
+	if got := messageLink(&tgbotapi.Message{Text: "text"}, bookmarkKey(link)); got != "" {
+		t.Errorf("message without links: got %q", got)
+	}
+}
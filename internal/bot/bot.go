@@ -1,9 +1,8 @@
 package bot
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil" // чтение файлов
+	"context"
+	"net/http"
 	"time"
 
 	"github.com/jasonlvhit/gocron" // Job Scheduling Package
@@ -11,31 +10,22 @@ import (
 
 	"github.com/ShoshinNikita/habrahabr-bot-go/internal/config"
 	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging" // логгирование
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
 )
 
 // Bot надстрройка над tgbotapi.BotAPI
 type Bot struct {
 	botAPI   *tgbotapi.BotAPI
 	messages chan tgbotapi.MessageConfig
+	// сообщения, сохранённые в базе данных до отправки (см. bot.enqueue)
+	outbox   chan outgoing
 	articles chan article
+	// новое значение минимальной задержки между сообщениями (см. bot.Reload)
+	rates chan time.Duration
 }
 
-// Список id, с которыми бот может взаимодействовать
-var correctIDs = []int64{}
-
-// ParseCorrectIDS парсит json-файл, в котором содержится список корректных id
-func ParseCorrectIDS(path string) error {
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return err
-	}
-	err = json.Unmarshal(data, &correctIDs)
-
-	msg := fmt.Sprintf("Корректные id: %v", correctIDs)
-	logging.LogInfo(msg)
-
-	return err
-}
+// Сколько времени при остановке бота ждать завершения планировщика и рассылки
+const shutdownTimeout = 15 * time.Second
 
 // NewBot инициализирует бота
 func NewBot() (*Bot, error) {
@@ -43,89 +33,167 @@ func NewBot() (*Bot, error) {
 
 	// Инициализация бота
 	var bot Bot
-	bot.botAPI, err = tgbotapi.NewBotAPI(config.Data.BotToken)
+	client := &http.Client{Transport: instrumentedTransport{next: http.DefaultTransport}}
+	bot.botAPI, err = tgbotapi.NewBotAPIWithClient(config.Get().BotToken, client)
 	if err != nil {
 		return nil, err
 	}
 
 	bot.botAPI.Buffer = 12 * 50
 	bot.messages = make(chan tgbotapi.MessageConfig, 300)
+	bot.outbox = make(chan outgoing, 300)
 	bot.articles = make(chan article, 60)
+	bot.rates = make(chan time.Duration, 1)
+	bot.registerMetrics()
 
 	return &bot, nil
 }
 
-// StartPooling начинает перехватывать сообщения
-func (bot *Bot) StartPooling(stopChan chan struct{}) {
-	// Получение канала обновлений
-	updateConfig := tgbotapi.NewUpdate(0)
-	updateConfig.Timeout = 60
-	updateChannel, err := bot.botAPI.GetUpdatesChan(updateConfig)
-	if err != nil {
-		logging.LogFatalError("StartPooling", "попытка получить GetUpdatesChan", err)
-	}
+// StartPooling начинает перехватывать сообщения. Функция завершается после отмены ctx,
+// когда остановлены все фоновые задачи и отправлены (или сохранены) оставшиеся сообщения
+func (bot *Bot) StartPooling(ctx context.Context) {
+	// Получение канала обновлений: через webhook, если указан его адрес, иначе – Long Pooling
+	var updateChannel tgbotapi.UpdatesChannel
+	var err error
+	if config.Get().WebhookURL != "" {
+		updateChannel, err = bot.listenWebhook(ctx)
+		if err != nil {
+			logging.LogFatalError("StartPooling", "попытка установить webhook", err)
+		}
+	} else {
+		// Оставшийся от запуска в режиме webhook адрес не даёт получать обновления через getUpdates
+		err = bot.deleteWebhook()
+		if err != nil {
+			logging.LogMinorError("StartPooling", "попытка удалить webhook", err)
+		}
 
-	allArticles := struct {
-		HabrArticles []string `json:"habr"`
-	}{[]string{}}
+		updateConfig := tgbotapi.NewUpdate(0)
+		updateConfig.Timeout = updateTimeout
+		updateChannel, err = bot.botAPI.GetUpdatesChan(updateConfig)
+		if err != nil {
+			logging.LogFatalError("StartPooling", "попытка получить GetUpdatesChan", err)
+		}
+	}
+	receivingStarted()
 
-	// Чтение lastArticles.json
-	raw, err := ioutil.ReadFile("data/lastArticles.json")
+	// Перенос обработанных статей из lastArticles.json (если файл остался от старой версии)
+	err = importLastArticles(config.DataPath(lastArticlesFile))
 	if err != nil {
-		logging.LogFatalError("StartPooling", "попытка прочесть lastArticles.json", err)
+		logging.LogMinorError("StartPooling", "попытка перенести lastArticles.json в базу данных", err)
 	}
-	json.Unmarshal(raw, &allArticles)
 
-	// Инициализация oldArticles
-	oldArticles = newSmartQueue(60, allArticles.HabrArticles)
+	// Сообщения, которые не успели отправиться до перезапуска. Список получается до старта рассылки и планировщика,
+	// чтобы не поставить в очередь повторно сообщения, которые они сохранят
+	unsent, err := userdb.GetOutbox()
+	if err != nil {
+		logging.LogMinorError("StartPooling", "попытка получить неотправленные сообщения", err)
+	}
 
 	// Старт рассылки
-	go bot.mailout()
+	mailoutDone := make(chan struct{})
+	go func() {
+		bot.mailout(ctx)
+		close(mailoutDone)
+	}()
+
+	scheduler := gocron.NewScheduler()
+	// Старт рассылки лучших статей каждый день в 21:00 по часовому поясу пользователя
+	scheduler.Every(5).Minutes().Do(bot.mailoutBestArticles)
+	// Проверка, кому пора отправлять дайджесты
+	scheduler.Every(5).Minutes().Do(bot.mailoutDigests)
+	// Отправка статей, отложенных на время тихих часов
+	scheduler.Every(1).Minute().Do(bot.releaseHeldArticles)
+	// Напоминания о закладках
+	scheduler.Every(1).Hour().Do(bot.remindBookmarks)
+	// Удаление давно неактивных пользователей
+	scheduler.Every(1).Day().At("04:00").Do(purgeInactiveUsers)
+	// Удаление старых ключей защиты от повторной отправки
+	scheduler.Every(1).Day().At("04:30").Do(cleanOutboxKeys)
+	// Удаление статей, которые давно пропали из лент источников
+	scheduler.Every(1).Day().At("05:00").Do(cleanSeenArticles)
+	// Удаление старых статей из архива
+	scheduler.Every(1).Day().At("05:30").Do(cleanArchive)
+	schedulerDone := make(chan struct{})
+	go func() {
+		runScheduler(ctx, scheduler)
+		close(schedulerDone)
+	}()
 
-	// Старт рассылки лучших статей каждый день в 21:00
-	gocron.Every(1).Day().At("21:00").Do(bot.mailoutBestArticles)
-	gocron.Start()
+	// Отправитель останавливается последним, чтобы отправить сообщения остальных задач
+	senderCtx, stopSender := context.WithCancel(context.Background())
+	senderDone := make(chan struct{})
+	go func() {
+		bot.sendWrapper(senderCtx, config.Get().Rate)
+		close(senderDone)
+	}()
+	// Отправка сообщений, которые не успели отправиться до перезапуска
+	go bot.resumeOutbox(unsent)
 
-	go bot.sendWrapper(config.Data.Rate)
+	// Метрики и проверки состояния
+	stopMetrics := bot.listenMetrics()
 
-	// Long pooling
-	for update := range updateChannel {
+	// Обработка обновлений (одинаковая для Long Pooling и webhook)
+	for {
 		select {
-		case <-stopChan:
-			{
-				// При закрытии канала завершаем выполнение функции.
-				// В этот цикл мы заходим только тогда, когда кто-то написал боту.
-				// Если кто-то писал, то функция сразу завершается, если нет – завершается вместе с программой
-				// При этом может потеряться часть запросов (они будут необработанны), но с этим ничего нельзя сделать
-				logging.LogInfo("Остановка Long Pooling")
-				return
-			}
-		default:
-			{
-				go bot.distributeUpdate(update)
+		case <-ctx.Done():
+			health.Lock()
+			health.stopping = true
+			health.Unlock()
+
+			// Новые обновления больше не принимаются. Webhook удаляется в listenWebhook
+			if config.Get().WebhookURL == "" {
+				bot.botAPI.StopReceivingUpdates()
 			}
+			logging.LogInfo("Остановка получения обновлений")
+
+			deadline := time.Now().Add(shutdownTimeout)
+			waitStopped("Планировщик", schedulerDone, deadline)
+			waitStopped("Рассылка", mailoutDone, deadline)
+
+			stopSender()
+			waitStopped("Отправка сообщений", senderDone, time.Now().Add(drainTimeout+time.Second))
+			stopMetrics()
+			return
+
+		case update := <-updateChannel:
+			go bot.distributeUpdate(update)
 		}
 	}
 }
 
-// distributeUpdate обрабатывает новые сообщения
-func (bot *Bot) distributeUpdate(update tgbotapi.Update) {
-	var isCorrectID = func(id int64) bool {
-		for i := range correctIDs {
-			if correctIDs[i] == id {
-				return true
-			}
+// runScheduler выполняет задачи планировщика, пока не будет отменён ctx.
+// Выполняемая в момент отмены задача завершается до выхода из функции
+func runScheduler(ctx context.Context, scheduler *gocron.Scheduler) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scheduler.RunPending()
 		}
-		return false
 	}
+}
 
-	if update.Message != nil {
-		if !isCorrectID(update.Message.Chat.ID) {
-			logging.LogInfo("Wrong ID: %d Username: %s Text: %s", update.Message.Chat.ID,
-				update.Message.Chat.UserName, update.Message.Text)
+// waitStopped ждёт закрытия канала done, но не дольше, чем до deadline
+func waitStopped(name string, done <-chan struct{}, deadline time.Time) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
 
-			message := tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный ID. Для подробностей писать @ShoshinNikita")
-			bot.messages <- message
+	select {
+	case <-done:
+	case <-timer.C:
+		logging.LogInfo("%s: не удалось дождаться завершения", name)
+	}
+}
+
+// distributeUpdate обрабатывает новые сообщения
+func (bot *Bot) distributeUpdate(update tgbotapi.Update) {
+	if update.Message != nil {
+		if !isAllowed(update.Message.Chat.ID) {
+			bot.handleUnknownUser(update.Message)
 			return
 		}
 
@@ -135,6 +203,22 @@ func (bot *Bot) distributeUpdate(update tgbotapi.Update) {
 			bot.messages <- message
 		}
 	}
+
+	if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+		if !isAllowed(update.CallbackQuery.Message.Chat.ID) {
+			return
+		}
+
+		bot.distributeCallback(update.CallbackQuery)
+	}
+
+	if update.InlineQuery != nil && update.InlineQuery.From != nil {
+		if !isAllowed(int64(update.InlineQuery.From.ID)) {
+			return
+		}
+
+		bot.answerInlineQuery(update.InlineQuery)
+	}
 }
 
 // distributeMessages распределяет сообщения по goroutine'ам
@@ -144,6 +228,13 @@ func (bot *Bot) distributeMessages(message *tgbotapi.Message) bool {
 
 	command := message.Command()
 	if command == "" {
+		// Ссылка на статью Habr (в том числе в пересланном сообщении)
+		if postID := getPostID(message.Text + " " + message.Caption); postID != "" {
+			logging.LogRequest(logging.RequestData{Command: "link", Username: message.Chat.UserName, ID: message.Chat.ID})
+			go bot.sendArticleCard(message, postID)
+			commandsTotal.Inc("link")
+			return true
+		}
 		return false
 	}
 
@@ -179,6 +270,110 @@ func (bot *Bot) distributeMessages(message *tgbotapi.Message) bool {
 		{
 			go bot.delAllTags(message)
 		}
+	case "filters":
+		{
+			go bot.getFilters(message)
+		}
+	case "add_filter":
+		{
+			go bot.addFilter(message)
+		}
+	case "del_filter":
+		{
+			go bot.delFilter(message)
+		}
+	case "keywords":
+		{
+			go bot.getKeywords(message)
+		}
+	case "add_keyword":
+		{
+			go bot.addKeywords(message)
+		}
+	case "del_keyword":
+		{
+			go bot.delKeywords(message)
+		}
+	case "add_regexp":
+		{
+			go bot.addRegexp(message)
+		}
+	case "del_regexp":
+		{
+			go bot.delRegexp(message)
+		}
+	case "authors":
+		{
+			go bot.getAuthors(message)
+		}
+	case "follow_author":
+		{
+			go bot.followAuthor(message)
+		}
+	case "block_author":
+		{
+			go bot.blockAuthor(message)
+		}
+	case "forget_author":
+		{
+			go bot.forgetAuthor(message)
+		}
+	case "follow_company":
+		{
+			go bot.followCompany(message)
+		}
+	case "block_company":
+		{
+			go bot.blockCompany(message)
+		}
+	case "forget_company":
+		{
+			go bot.forgetCompany(message)
+		}
+	case "digest":
+		{
+			go bot.setDelivery(message)
+		}
+	case "timezone":
+		{
+			go bot.setTimezone(message)
+		}
+	case "quiet":
+		{
+			go bot.setQuietHours(message)
+		}
+	case "saved":
+		{
+			go bot.getBookmarks(message)
+		}
+	case "unsave":
+		{
+			go bot.unsave(message)
+		}
+	case "remind":
+		{
+			go bot.setRemind(message)
+		}
+	case "best_mailout":
+		{
+			go bot.setBestMailout(message)
+		}
+	case "search":
+		{
+			go bot.search(message)
+		}
+	case "feeds":
+		{
+			go bot.getFeeds(message)
+		}
+	case "add_feed":
+		{
+			go bot.addFeed(message)
+		}
+	case "del_feed":
+		{
+			go bot.delFeed(message)
+		}
 	case "best":
 		{
 			go bot.getBest(message)
@@ -187,35 +382,47 @@ func (bot *Bot) distributeMessages(message *tgbotapi.Message) bool {
 		{
 			go bot.copyTags(message)
 		}
+	case "reload":
+		{
+			go bot.reloadCommand(message)
+		}
+	case "allow":
+		{
+			go bot.allowUser(message)
+		}
+	case "deny", "ban":
+		{
+			go bot.denyUser(message)
+		}
+	case "banned":
+		{
+			go bot.getBanned(message)
+		}
+	case "invite":
+		{
+			go bot.createInvite(message)
+		}
+	case "stats":
+		{
+			go bot.getStats(message)
+		}
+	case "user":
+		{
+			go bot.getUserInfo(message)
+		}
+	case "broadcast":
+		{
+			go bot.broadcast(message)
+		}
 	default:
 		{
 			isRightCommand = false
 		}
 	}
 
-	return isRightCommand
-}
-
-// send отправляет сообщение
-func (bot *Bot) send(msg tgbotapi.MessageConfig) {
-	_, err := bot.botAPI.Send(msg)
-	if err != nil {
-		if err.Error() != "Forbidden: bot was blocked by the user" &&
-			err.Error() != "Forbidden: user is deactivated" {
-			text := fmt.Sprintf("UserID: %d", msg.ChatID)
-			logging.LogMinorError("send", text, err)
-		}
+	if isRightCommand {
+		commandsTotal.Inc(command)
 	}
-}
 
-// messageHandler – обёртка над bot.send()
-// Отправляет сообщения раз в rate time.Duration
-func (bot *Bot) sendWrapper(milliseconds uint64) {
-	nanoseconds := milliseconds * 1000000
-	rate := time.Duration(nanoseconds)
-	limiter := time.Tick(rate)
-	for message := range bot.messages {
-		<-limiter
-		go bot.send(message)
-	}
+	return isRightCommand
 }
@@ -0,0 +1,163 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+// Префиксы данных inline-кнопок
+const (
+	// сохранить статью в закладки (save:<ключ закладки>, см. bookmarkKey)
+	callbackSave = "save:"
+	// подписаться на тег статьи (tag:<номер поста>:<тег>)
+	callbackTag = "tag:"
+	// удалить тег (untag:<тег>)
+	callbackUntag = "untag:"
+	// показать страницу списка закладок (saved:<номер страницы с 0>)
+	callbackSaved = "saved:"
+	// показать страницу результатов поиска (search:<номер страницы с 0>)
+	callbackSearch = "search:"
+	// разрешить или запретить доступ (access:<allowed или denied>:<id>)
+	callbackAccess = "access:"
+	// подтвердить или отменить рассылку (broadcast:<id>, broadcast:cancel:<id>)
+	callbackBroadcast = "broadcast:"
+)
+
+// Максимальная длина данных inline-кнопки (ограничение Telegram)
+const maxCallbackData = 64
+
+// distributeCallback обрабатывает нажатия на inline-кнопки
+func (bot *Bot) distributeCallback(query *tgbotapi.CallbackQuery) {
+	if query.Message == nil {
+		bot.answerCallback(query, "")
+		return
+	}
+
+	logging.LogRequest(logging.RequestData{Command: "callback " + query.Data, Username: query.Message.Chat.UserName,
+		ID: query.Message.Chat.ID})
+
+	switch {
+	case strings.HasPrefix(query.Data, callbackSave):
+		bot.saveFromCallback(query, strings.TrimPrefix(query.Data, callbackSave))
+	case strings.HasPrefix(query.Data, callbackTag):
+		bot.subscribeFromCallback(query, strings.TrimPrefix(query.Data, callbackTag))
+	case strings.HasPrefix(query.Data, callbackUntag):
+		bot.unsubscribeFromCallback(query, strings.TrimPrefix(query.Data, callbackUntag))
+	case strings.HasPrefix(query.Data, callbackSaved):
+		bot.bookmarksFromCallback(query, strings.TrimPrefix(query.Data, callbackSaved))
+	case strings.HasPrefix(query.Data, callbackSearch):
+		bot.searchFromCallback(query, strings.TrimPrefix(query.Data, callbackSearch))
+	case strings.HasPrefix(query.Data, callbackAccess):
+		bot.accessFromCallback(query, strings.TrimPrefix(query.Data, callbackAccess))
+	case strings.HasPrefix(query.Data, callbackBroadcast):
+		bot.broadcastFromCallback(query, strings.TrimPrefix(query.Data, callbackBroadcast))
+	default:
+		bot.answerCallback(query, "Неизвестная кнопка")
+	}
+}
+
+// answerCallback отвечает на нажатие кнопки (текст показывается пользователю во всплывающем уведомлении)
+func (bot *Bot) answerCallback(query *tgbotapi.CallbackQuery, text string) {
+	_, err := bot.botAPI.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, text))
+	if err != nil {
+		logging.LogMinorError("answerCallback", "попытка ответить на нажатие кнопки "+query.Data, err)
+	}
+}
+
+// editMessage заменяет текст и кнопки сообщения
+func (bot *Bot) editMessage(chatID int64, messageID int, text string, markup *tgbotapi.InlineKeyboardMarkup) {
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ParseMode = "HTML"
+	edit.DisableWebPagePreview = true
+	edit.ReplyMarkup = markup
+
+	_, err := bot.botAPI.Send(edit)
+	if err != nil {
+		logging.LogMinorError("editMessage", fmt.Sprintf("UserID: %d", chatID), err)
+	}
+}
+
+// editReplyMarkup заменяет кнопки сообщения
+func (bot *Bot) editReplyMarkup(chatID int64, messageID int, markup interface{}) {
+	keyboard, ok := markup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok {
+		return
+	}
+
+	_, err := bot.botAPI.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard))
+	if err != nil {
+		logging.LogMinorError("editReplyMarkup", fmt.Sprintf("UserID: %d", chatID), err)
+	}
+}
+
+// saveFromCallback сохраняет статью в закладки.
+// Для статей Habr заголовок и теги берутся из архива или со страницы статьи. Остальные статьи (например, из
+// пользовательских лент) не хранятся в архиве: ссылка берётся из сообщения с кнопкой. Если статью не удалось найти,
+// заголовок – первая строка сообщения с кнопкой
+func (bot *Bot) saveFromCallback(query *tgbotapi.CallbackQuery, key string) {
+	bookmark := userdb.Bookmark{
+		PostID:  key,
+		Title:   strings.SplitN(query.Message.Text, "\n", 2)[0],
+		SavedAt: time.Now(),
+	}
+
+	if _, err := strconv.ParseUint(key, 10, 64); err == nil {
+		postID := key
+		bookmark.Link = fmt.Sprintf(habrPostURL, postID)
+		if a, ok, err := userdb.GetArchived(postID); err == nil && ok {
+			bookmark.Title = a.Title
+			bookmark.Tags = a.Tags
+		} else if card, err := getArticleCard(postID); err == nil {
+			bookmark.Title = card.title
+			for _, hub := range card.hubs {
+				bookmark.Tags = append(bookmark.Tags, normalizeTag(hub))
+			}
+		}
+	} else {
+		bookmark.Link = messageLink(query.Message, key)
+		if bookmark.Link == "" {
+			bot.answerCallback(query, "Неверная ссылка на статью")
+			return
+		}
+	}
+
+	added, err := userdb.AddBookmark(strconv.FormatInt(query.Message.Chat.ID, 10), bookmark)
+	if err != nil {
+		logging.LogMinorError("saveFromCallback", fmt.Sprintf("UserID: %d Key: %s", query.Message.Chat.ID, key), err)
+		bot.answerCallback(query, "Что-то пошло не так")
+		return
+	}
+
+	if !added {
+		bot.answerCallback(query, "Статья уже в закладках")
+		return
+	}
+	bot.answerCallback(query, "🔖 Статья сохранена в закладки")
+}
+
+// bookmarksFromCallback показывает другую страницу списка закладок в том же сообщении
+func (bot *Bot) bookmarksFromCallback(query *tgbotapi.CallbackQuery, pageData string) {
+	page, err := strconv.Atoi(pageData)
+	if err != nil {
+		bot.answerCallback(query, "Неверная страница")
+		return
+	}
+
+	bookmarks, err := userdb.GetBookmarks(strconv.FormatInt(query.Message.Chat.ID, 10))
+	if err != nil {
+		logging.LogMinorError("bookmarksFromCallback", fmt.Sprintf("UserID: %d", query.Message.Chat.ID), err)
+		bot.answerCallback(query, "Что-то пошло не так")
+		return
+	}
+
+	text, markup := bookmarksPage(bookmarks, page)
+	bot.editMessage(query.Message.Chat.ID, query.Message.MessageID, text, markup)
+	bot.answerCallback(query, "")
+}
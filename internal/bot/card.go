@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anaskhan96/soup"
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+var habrArticleRegexp = regexp.MustCompile(habrArticleRegexPattern)
+
+// articleCard – информация о статье Habr, полученная со страницы статьи
+type articleCard struct {
+	postID      string
+	title       string
+	link        string
+	author      string
+	hubs        []string
+	published   time.Time
+	readingTime string
+	rating      string
+}
+
+// getPostID возвращает номер поста из первой ссылки на статью Habr в тексте. Если ссылки нет – ""
+func getPostID(text string) string {
+	match := habrArticleRegexp.FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	return match[len(match)-1]
+}
+
+// getArticleCard загружает страницу статьи и возвращает информацию о ней
+func getArticleCard(postID string) (articleCard, error) {
+	card := articleCard{postID: postID, link: fmt.Sprintf(habrPostURL, postID)}
+
+	resp, err := fetchPage(card.link)
+	if err != nil {
+		return card, err
+	}
+	doc := soup.HTMLParse(resp)
+
+	titleNode := doc.Find("h1", "class", "tm-title")
+	if titleNode.Error != nil {
+		return card, errors.New("не удалось найти заголовок статьи " + card.link)
+	}
+	card.title = strings.TrimSpace(titleNode.FullText())
+
+	if node := doc.Find("a", "class", "tm-user-info__username"); node.Error == nil {
+		card.author = strings.TrimSpace(node.FullText())
+	}
+
+	if node := doc.Find("span", "class", "tm-article-datetime-published"); node.Error == nil {
+		if timeNode := node.Find("time"); timeNode.Error == nil {
+			card.published, _ = time.Parse(time.RFC3339, timeNode.Attrs()["datetime"])
+		}
+	}
+
+	if node := doc.Find("span", "class", "tm-article-reading-time__label"); node.Error == nil {
+		card.readingTime = strings.TrimSpace(node.FullText())
+	}
+
+	if node := doc.Find("span", "class", "tm-votes-meter__value"); node.Error == nil {
+		card.rating = strings.TrimSpace(node.FullText())
+	}
+
+	for _, hubNode := range doc.FindAll("a", "class", "tm-publication-hub__link") {
+		if span := hubNode.Find("span"); span.Error == nil {
+			card.hubs = append(card.hubs, strings.TrimSpace(span.Text()))
+		}
+	}
+
+	return card, nil
+}
+
+// formatCard возвращает текст карточки статьи
+func formatCard(card articleCard, loc *time.Location) string {
+	text := "<b>" + html.EscapeString(card.title) + "</b>\n\n"
+
+	if card.author != "" {
+		text += "👤 " + html.EscapeString(card.author) + "\n"
+	}
+	if len(card.hubs) > 0 {
+		text += "📚 " + html.EscapeString(strings.Join(card.hubs, ", ")) + "\n"
+	}
+	if !card.published.IsZero() {
+		text += "📅 " + card.published.In(loc).Format("02.01.2006 15:04") + "\n"
+	}
+	if card.readingTime != "" {
+		text += "⏱ " + html.EscapeString(card.readingTime) + "\n"
+	}
+	if card.rating != "" {
+		text += "⭐ Рейтинг: " + html.EscapeString(card.rating) + "\n"
+	}
+
+	text += "\n" + formatString("<a href='{link}'>Открыть статью</a>", map[string]string{"link": card.link})
+	return text
+}
+
+// sendArticleCard отправляет пользователю карточку статьи, ссылку на которую он прислал
+func (bot *Bot) sendArticleCard(msg *tgbotapi.Message, postID string) {
+	card, err := getArticleCard(postID)
+	if err != nil {
+		logging.LogMinorError("sendArticleCard", "попытка загрузить статью "+postID, err)
+		bot.sendErrorToUser("не удалось загрузить статью", msg.Chat.ID)
+		return
+	}
+
+	var tags []string
+	if a, ok, err := userdb.GetArchived(postID); err == nil && ok {
+		tags = a.Tags
+	} else {
+		for _, hub := range card.hubs {
+			tags = append(tags, normalizeTag(hub))
+		}
+		// Статья сохраняется в архив, чтобы кнопки подписки на теги работали без повторной загрузки страницы
+		archiveArticle(article{title: card.title, link: card.link, tags: tags, author: normalizeAuthor(card.author), published: card.published})
+	}
+
+	loc := time.Local
+	var userTags []string
+	if user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10)); err == nil {
+		loc = userLocation(user)
+		userTags = user.Tags
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, formatCard(card, loc))
+	message.ParseMode = "HTML"
+	message.DisableWebPagePreview = true
+	message.ReplyToMessageID = msg.MessageID
+	message.ReplyMarkup = articleKeyboard(card.link, tags, userTags)
+	bot.messages <- message
+}
@@ -0,0 +1,32 @@
+package bot
+
+import "testing"
+
+func TestGetPostID(t *testing.T) {
+	tests := []struct {
+		text   string
+		postID string
+	}{
+		{"https://habr.com/ru/articles/765432/", "765432"},
+		{"https://habr.com/ru/articles/765432/?utm_source=habrahabr&utm_medium=rss&utm_campaign=765432", "765432"},
+		{"https://habr.com/en/articles/773090/", "773090"},
+		{"https://habr.com/ru/companies/yandex/articles/765431/", "765431"},
+		{"https://habr.com/ru/companies/sberbank/news/766106/", "766106"},
+		{"https://habr.com/ru/company/mailru/blog/452124/", "452124"},
+		{"https://habr.com/ru/news/t/452100/", "452100"},
+		{"https://habr.com/ru/post/452100/", "452100"},
+		{"habrahabr.ru/post/333180", "333180"},
+		{"Посмотри: https://habr.com/ru/companies/otus/articles/765940/ – интересно", "765940"},
+		// Не статьи
+		{"https://habr.com/ru/companies/yandex/", ""},
+		{"https://habr.com/ru/users/tirsias/", ""},
+		{"https://example.com/ru/articles/765432/", ""},
+		{"просто текст", ""},
+	}
+
+	for _, tt := range tests {
+		if got := getPostID(tt.text); got != tt.postID {
+			t.Errorf("getPostID(%q) = %q, want %q", tt.text, got, tt.postID)
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"strings"
 
 	"github.com/anaskhan96/soup"   // html parser
-	"github.com/mmcdole/gofeed"    // Rss parser
 	"gopkg.in/telegram-bot-api.v4" // Telegram api
 
 	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging" // логгирование
@@ -71,25 +70,11 @@ func (bot *Bot) getStatus(msg *tgbotapi.Message) {
 		return
 	}
 
-	tags := user.Tags
-
-	var text string
-	if len(tags) == 0 {
-		text = "Список тегов пуст"
-	} else {
-		text = "Список тегов:\n* "
-		text += strings.Join(tags, "\n* ")
-	}
-
-	text += "\n\n📬 Рассылка: "
-
-	if user.Mailout {
-		text += "осуществляется"
-	} else {
-		text += "не осуществляется"
+	message := tgbotapi.NewMessage(msg.Chat.ID, statusText(user))
+	message.ParseMode = "HTML"
+	if markup := tagsKeyboard(user.Tags); markup != nil {
+		message.ReplyMarkup = markup
 	}
-
-	message := tgbotapi.NewMessage(msg.Chat.ID, text)
 	bot.messages <- message
 }
 
@@ -247,43 +232,101 @@ func (bot *Bot) copyTags(msg *tgbotapi.Message) {
 	bot.messages <- message
 }
 
-// getBest отправляет пользователю лучшие статьи за сегодняшний день.
-// По-умолчанию – 5, если пользователь указал другое число - другое
-func (bot *Bot) getBest(msg *tgbotapi.Message) {
-	parser := gofeed.NewParser()
-	feed, err := parser.ParseURL(bestRuHabrArticlesURL)
+// filtersText возвращает список фильтров в виде текста
+func filtersText(filters []string) string {
+	if len(filters) == 0 {
+		return "Список фильтров пуст"
+	}
+
+	text := "Список фильтров:\n"
+	for i, filter := range filters {
+		text += strconv.Itoa(i+1) + ") " + filter + "\n"
+	}
+	return text
+}
+
+// addFilter добавляет фильтр по тегам (пример: /add_filter go -вакансии)
+func (bot *Bot) addFilter(msg *tgbotapi.Message) {
+	filter := strings.Join(strings.Fields(strings.ToLower(msg.CommandArguments())), " ")
+	if _, err := parseFilter(filter); err != nil {
+		bot.sendErrorToUser(err.Error(), msg.Chat.ID)
+		return
+	}
+
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...add_filter",
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+	if len(user.Filters) >= maxUserFilters {
+		bot.sendErrorToUser("нельзя добавить больше "+strconv.Itoa(maxUserFilters)+" фильтров", msg.Chat.ID)
+		return
+	}
+
+	filters, err := userdb.AddUserFilter(strconv.FormatInt(msg.Chat.ID, 10), filter)
 	if err != nil {
 		data := logging.ErrorData{
 			Error:    err,
 			Username: msg.Chat.UserName,
 			UserID:   msg.Chat.ID,
-			Command:  "/...best",
-			AddInfo:  "попытка распарсить RSS-ленту"}
+			Command:  "/...add_filter",
+			AddInfo:  "попытка добавить фильтр"}
 		bot.logErrorAndNotify(data)
 		return
 	}
 
-	bestArticles := "<b>Лучшие статьи за этот день:</b>\n"
-	limit := 5
-	// Проверка, было ли задано другое количество статей
-	if msg.CommandArguments() != "" {
-		temp, err := strconv.Atoi(msg.CommandArguments())
-		if err == nil && temp > 0 {
-			limit = temp
-		}
+	message := tgbotapi.NewMessage(msg.Chat.ID, filtersText(filters))
+	bot.messages <- message
+}
+
+// delFilter удаляет фильтр по его номеру из списка /filters
+func (bot *Bot) delFilter(msg *tgbotapi.Message) {
+	number, err := strconv.Atoi(strings.TrimSpace(msg.CommandArguments()))
+	if err != nil {
+		bot.sendErrorToUser("нужно указать номер фильтра (пример: /del_filter 1)", msg.Chat.ID)
+		return
 	}
 
-	// Создание списка статей (в виде строки)
-	for i, item := range feed.Items {
-		if i >= limit {
-			break
-		}
-		number := strconv.Itoa(i + 1)
-		bestArticles += number + ") " + formatString("<a href='{link}'>{title}</a>", map[string]string{"link": item.Link, "title": item.Title}) + "\n"
+	filters, err := userdb.DelUserFilter(strconv.FormatInt(msg.Chat.ID, 10), number)
+	if err == userdb.ErrNoSuchElement {
+		bot.sendErrorToUser("фильтра с таким номером нет", msg.Chat.ID)
+		return
+	}
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...del_filter",
+			AddInfo:  "попытка удалить фильтр"}
+		bot.logErrorAndNotify(data)
+		return
 	}
 
-	message := tgbotapi.NewMessage(msg.Chat.ID, bestArticles)
-	message.ParseMode = "HTML"
-	message.DisableWebPagePreview = true
+	message := tgbotapi.NewMessage(msg.Chat.ID, filtersText(filters))
+	bot.messages <- message
+}
+
+// getFilters отправляет пользователю список его фильтров
+func (bot *Bot) getFilters(msg *tgbotapi.Message) {
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...filters",
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, filtersText(user.Filters))
 	bot.messages <- message
 }
@@ -1,7 +1,11 @@
 package bot
 
 // Константы для определения сайта
-const habrArticleRegexPattern = `(https://)?(habrahabr\.ru|habr\.com|habr\.ru)/(ru|en|)/(post|company/[\w-_ ]+/blog)/\d{1,7}/?`
+// Последняя группа – номер поста
+const habrArticleRegexPattern = `(https://)?(habrahabr\.ru|habr\.com|habr\.ru)/(?:ru/|en/)?(post|articles|news|news/t|company/[\w-]+/blog|companies/[\w-]+/(?:articles|news))/(\d{1,8})/?`
+
+// Ссылка на статью по номеру поста. Нужно отформатировать функцией fmt.Sprintf(habrPostURL, postID)
+const habrPostURL = "https://habr.com/ru/post/%s/"
 
 const habrUserRegexPattern = `^(https://)?(habrahabr\.ru|habr\.com|habr\.ru)/users/[\w\s-]+/?$`
 
@@ -16,20 +20,59 @@ const (
 	allRuHabrArticlesURL = "https://habr.com/ru/rss/all/"
 	allEnHabrArticlesURL = "https://habr.com/en/rss/all/"
 
-	bestRuHabrArticlesURL = "https://habr.com/ru/rss/best/"
-	bestEnHabrArticlesURL = "https://habr.com/en/rss/best/"
+	// Страницы со списком статей (для восстановления пропущенных статей)
+	// Нужно отформатировать функцией fmt.Sprintf(url, page)
+	allRuHabrListingURL = "https://habr.com/ru/articles/page%d/"
+	allEnHabrListingURL = "https://habr.com/en/articles/page%d/"
+
+	// Лента поиска по статьям. Нужно отформатировать функцией fmt.Sprintf(searchHabrArticlesURL, url.QueryEscape(query))
+	searchHabrArticlesURL = "https://habr.com/ru/rss/search/?q=%s&target_type=posts&order=relevance"
+
+	// Лучшие статьи. Нужно отформатировать функцией fmt.Sprintf(bestHabrArticlesURL, lang, period),
+	// где lang – "ru" или "en", period – "daily", "weekly", "monthly" или "yearly"
+	bestHabrArticlesURL = "https://habr.com/%s/rss/best/%s/"
 )
 
 const helpText = `📝 <b>КОМАНДЫ</b>:
 * /help – показать помощь
-* /tags – показать 📃 список тегов, на которые пользователь подписан
+* /tags – показать 📃 список тегов, на которые пользователь подписан (тег можно удалить кнопкой ❌)
 * /add_tags – добавить теги (пример: /add_tags IT Алгоритмы)
 * /del_tags – удалить теги (пример: /del_tags IT Алгоритмы)
 * /del_all_tags – ❌ удалить ВСЕ теги
 * /copy_tags – ✂️ скопировать теги из профиля на habrahabr'e (пример: /copy_tags https://habrahabr.ru/users/kirtis/)
-* /best – получить лучшие статьи за день (по-умолчанию присылается 5, но можно через пробел указать другое количество)
+* /filters – показать 🔎 список фильтров по тегам
+* /add_filter – добавить фильтр: AND, OR, NOT, скобки и -тег для исключения, которое действует и на теги из /tags (пример: /add_filter go -вакансии, /add_filter kubernetes AND security)
+* /del_filter – удалить фильтр (пример: /del_filter 1 – номер из списка /filters)
+* /keywords – показать 🔤 список ключевых слов и регулярных выражений
+* /add_keyword – искать слова в заголовке и описании статьи: русские – с любым окончанием, остальные – целиком (пример: /add_keyword postgresql база)
+* /del_keyword – удалить ключевые слова (пример: /del_keyword postgres)
+* /add_regexp – искать по регулярному выражению без учёта регистра (пример: /add_regexp go\s?1\.\d+)
+* /del_regexp – удалить регулярное выражение (пример: /del_regexp 1 – номер из списка /keywords)
+* /authors – показать 👤 списки авторов и компаний
+* /follow_author – получать все статьи автора, независимо от тегов (пример: /follow_author @kirtis)
+* /block_author – никогда не получать статьи автора (пример: /block_author @kirtis)
+* /forget_author – удалить автора из списков (пример: /forget_author @kirtis)
+* /follow_company – получать все статьи из блога компании (пример: /follow_company yandex)
+* /block_company – никогда не получать статьи из блога компании (пример: /block_company yandex)
+* /forget_company – удалить компанию из списков (пример: /forget_company yandex)
+* /feeds – показать 📰 список RSS/Atom-лент, на которые пользователь подписан
+* /add_feed – подписаться на RSS/Atom-ленту (пример: /add_feed https://blog.golang.org/feed.atom)
+* /del_feed – отписаться от ленты (пример: /del_feed 1 – номер из списка /feeds)
+* /digest – присылать статьи сразу или 📰 дайджестом: instant, hourly, daily, weekly (пример: /digest daily)
+* /timezone – 🕒 установить часовой пояс (пример: /timezone Europe/Moscow)
+* /quiet – 🌙 тихие часы: hold – отложить статьи, silent – присылать без звука (пример: /quiet 23:00-08:00 silent, /quiet off)
+* /search – 🔎 найти статьи в архиве бота (пример: /search горутины)
+* /saved – 🔖 показать закладки (пример: /saved 2 – вторая страница)
+* /unsave – удалить закладку (пример: /unsave 1 – номер из списка /saved)
+* /remind – ⏰ напоминать о закладках через N дней (пример: /remind 7, /remind off)
+* /best – получить лучшие статьи: период (day, week, month, year), язык (ru, en, all), mine – только по вашим тегам, количество (пример: /best week all mine 10, по-умолчанию – 5 статей на русском за день)
+* /best_mailout – настроить ежедневную рассылку лучших статей в 21:00, аргументы те же (пример: /best_mailout week mine, /best_mailout off)
 * /stop – 🔕 приостановить рассылку (для продолжения рассылки - /start)
 
+🔎 В любом чате можно набрать @имя_бота и запрос, чтобы найти статью и отправить её в чат
+
+🔗 Если прислать (или переслать) ссылку на статью Habr, бот ответит карточкой статьи с кнопками для сохранения в 🔖 закладки и подписки на хабы
+
 <a href= 'http://telegra.ph/Kak-polzovatsya-unofficial-habr-bot-03-09'>Дополнительная информация</a>`
 
 /*
@@ -41,6 +84,32 @@ add_tags - добавить теги
 del_tags - удалить теги
 del_all_tags - удалить ВСЕ теги
 copy_tags - скопировать теги из профиля на habrahabr'e
+filters - показать список фильтров
+add_filter - добавить фильтр по тегам
+del_filter - удалить фильтр
+keywords - показать ключевые слова и регулярные выражения
+add_keyword - добавить ключевые слова
+del_keyword - удалить ключевые слова
+add_regexp - добавить регулярное выражение
+del_regexp - удалить регулярное выражение
+authors - показать списки авторов и компаний
+follow_author - подписаться на автора
+block_author - заблокировать автора
+forget_author - удалить автора из списков
+follow_company - подписаться на блог компании
+block_company - заблокировать блог компании
+forget_company - удалить компанию из списков
+feeds - показать список RSS/Atom-лент
+add_feed - подписаться на RSS/Atom-ленту
+del_feed - отписаться от ленты
+digest - настроить дайджест
+timezone - установить часовой пояс
+quiet - настроить тихие часы
+search - найти статьи
+saved - показать закладки
+unsave - удалить закладку
+remind - напоминать о закладках
 stop - приостановить рассылку
-best - получить лучшие статьи за день
+best - получить лучшие статьи
+best_mailout - настроить рассылку лучших статей
 */
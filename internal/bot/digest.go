@@ -0,0 +1,234 @@
+package bot
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+const (
+	// Час, в который отправляются ежедневные и еженедельные дайджесты
+	digestHour = 9
+	// День недели, в который отправляются еженедельные дайджесты
+	digestWeekday = time.Monday
+	// Максимальная длина сообщения в Telegram
+	telegramMessageLimit = 4096
+)
+
+// Названия режимов доставки для пользователя
+var deliveryNames = map[string]string{
+	userdb.DeliveryInstant: "сразу",
+	userdb.DeliveryHourly:  "раз в час",
+	userdb.DeliveryDaily:   "раз в день",
+	userdb.DeliveryWeekly:  "раз в неделю",
+}
+
+// isDigestTime проверяет, пора ли отправлять дайджест пользователю (по его часовому поясу)
+func isDigestTime(user userdb.User, now time.Time) bool {
+	sentAt := user.DigestSentAt
+	now = now.In(userLocation(user))
+
+	switch user.Delivery {
+	case userdb.DeliveryHourly:
+		return now.Sub(sentAt) >= time.Hour
+	case userdb.DeliveryDaily:
+		return now.Hour() >= digestHour && !isSameDay(sentAt, now)
+	case userdb.DeliveryWeekly:
+		return now.Weekday() == digestWeekday && now.Hour() >= digestHour && now.Sub(sentAt) >= 24*time.Hour
+	default:
+		return false
+	}
+}
+
+// isSameDay проверяет, относятся ли два момента времени к одному дню (в часовом поясе b)
+func isSameDay(a, b time.Time) bool {
+	a = a.In(b.Location())
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// splitMessage собирает строки в сообщения, не превышающие лимит Telegram. Строки не разрываются:
+// в HTML-сообщении разрыв может попасть внутрь тега или сущности, поэтому каждая строка должна быть
+// не длиннее лимита (см. formatLinkLine)
+func splitMessage(header string, lines []string) []string {
+	var messages []string
+
+	current := header
+	for _, line := range lines {
+		if utf8.RuneCountInString(current)+utf8.RuneCountInString(line)+1 > telegramMessageLimit && current != "" {
+			messages = append(messages, current)
+			current = ""
+		}
+		if current != "" {
+			current += "\n"
+		}
+		current += line
+	}
+	if current != "" {
+		messages = append(messages, current)
+	}
+
+	return messages
+}
+
+// formatLinkLine возвращает пункт списка статей в формате HTML ("1) <a href='…'>заголовок</a>"), не длиннее
+// лимита Telegram. Слишком длинный заголовок обрезается до экранирования, поэтому разметка всегда корректна.
+// Если в лимит не помещается даже ссылка, пункт выводится без неё
+func formatLinkLine(n int, title, link string) string {
+	prefix := strconv.Itoa(n) + ") "
+	anchor := formatString("<a href='{link}'>", map[string]string{"link": html.EscapeString(link)})
+
+	limit := telegramMessageLimit - utf8.RuneCountInString(prefix+anchor+"</a>")
+	if limit < 1 {
+		return prefix + truncateEscaped(title, telegramMessageLimit-utf8.RuneCountInString(prefix))
+	}
+	return prefix + anchor + truncateEscaped(title, limit) + "</a>"
+}
+
+// truncateEscaped экранирует s и обрезает результат до limit символов. Строка обрезается только
+// между экранированными символами, в конце обрезанной строки добавляется "…"
+func truncateEscaped(s string, limit int) string {
+	escaped := html.EscapeString(s)
+	if utf8.RuneCountInString(escaped) <= limit {
+		return escaped
+	}
+
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, r := range s {
+		e := html.EscapeString(string(r))
+		// Один символ оставляем для "…"
+		if n+utf8.RuneCountInString(e) > limit-1 {
+			break
+		}
+		b.WriteString(e)
+		n += utf8.RuneCountInString(e)
+	}
+	return b.String() + "…"
+}
+
+// formatDigest возвращает текст дайджеста, разбитый на сообщения
+func formatDigest(articles []userdb.PendingArticle) []string {
+	header := "<b>📰 Дайджест статей (" + strconv.Itoa(len(articles)) + "):</b>\n"
+
+	lines := make([]string, 0, len(articles))
+	for i, a := range articles {
+		lines = append(lines, formatLinkLine(i+1, a.Title, a.Link))
+	}
+
+	return splitMessage(header, lines)
+}
+
+// addToDigest откладывает статью до отправки дайджеста
+func addToDigest(user userdb.User, a article) {
+	err := userdb.AddPendingArticle(strconv.FormatInt(user.ID, 10), userdb.PendingArticle{Title: a.title, Link: a.link})
+	if err != nil {
+		logging.LogMinorError("addToDigest", "попытка отложить статью для пользователя "+strconv.FormatInt(user.ID, 10), err)
+	}
+}
+
+// mailoutDigests рассылает дайджесты пользователям, которым пора их получить
+func (bot *Bot) mailoutDigests() {
+	users, err := userdb.GetActiveUsers()
+	if err != nil {
+		logging.LogMinorError("mailoutDigests", "попытка получить список пользователей", err)
+		return
+	}
+
+	now := time.Now()
+	for _, user := range users {
+		if user.Mailout && isDigestTime(user, now) {
+			bot.flushDigest(user, now)
+		}
+	}
+}
+
+// flushDigest отправляет пользователю накопленные статьи одним дайджестом
+func (bot *Bot) flushDigest(user userdb.User, now time.Time) {
+	id := strconv.FormatInt(user.ID, 10)
+	articles, err := userdb.TakePendingArticles(id)
+	if err != nil {
+		logging.LogMinorError("flushDigest", "попытка получить дайджест пользователя "+id, err)
+		return
+	}
+
+	if len(articles) > 0 {
+		for _, text := range formatDigest(articles) {
+			message := tgbotapi.NewMessage(user.ID, text)
+			message.ParseMode = "HTML"
+			message.DisableWebPagePreview = true
+			message.DisableNotification = inQuietHours(user, now)
+			bot.enqueue(message, "")
+		}
+	}
+
+	err = userdb.SetDigestSentAt(id, now)
+	if err != nil {
+		logging.LogMinorError("flushDigest", "попытка сохранить время отправки дайджеста пользователя "+id, err)
+	}
+}
+
+// setDelivery устанавливает режим доставки статей (пример: /digest daily)
+// Без аргументов показывает текущий режим
+func (bot *Bot) setDelivery(msg *tgbotapi.Message) {
+	mode := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	id := strconv.FormatInt(msg.Chat.ID, 10)
+
+	if mode == "" {
+		user, err := userdb.GetUser(id)
+		if err != nil {
+			data := logging.ErrorData{
+				Error:    err,
+				Username: msg.Chat.UserName,
+				UserID:   msg.Chat.ID,
+				Command:  "/...digest",
+				AddInfo:  "попытка получить данные пользователя"}
+			bot.logErrorAndNotify(data)
+			return
+		}
+
+		text := "Статьи присылаются: " + deliveryNames[user.Delivery] +
+			"\n\nДоступные режимы: instant, hourly, daily, weekly (пример: /digest daily)"
+		message := tgbotapi.NewMessage(msg.Chat.ID, text)
+		bot.messages <- message
+		return
+	}
+
+	if _, ok := deliveryNames[mode]; !ok {
+		bot.sendErrorToUser("неизвестный режим. Доступные режимы: instant, hourly, daily, weekly", msg.Chat.ID)
+		return
+	}
+
+	err := userdb.SetDelivery(id, mode)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...digest",
+			AddInfo:  "попытка изменить режим доставки"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, "Теперь статьи присылаются: "+deliveryNames[mode])
+	bot.messages <- message
+
+	// При переходе на мгновенную доставку накопленные статьи отправляются сразу
+	if mode == userdb.DeliveryInstant {
+		user, err := userdb.GetUser(id)
+		if err != nil {
+			logging.LogMinorError("setDelivery", "попытка получить данные пользователя "+id, err)
+			return
+		}
+		bot.flushDigest(user, time.Now())
+	}
+}
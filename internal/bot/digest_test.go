@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+func TestSplitMessage(t *testing.T) {
+	checkLimit := func(t *testing.T, messages []string) {
+		for i, msg := range messages {
+			if n := utf8.RuneCountInString(msg); n > telegramMessageLimit {
+				t.Errorf("message %d is %d runes long", i, n)
+			}
+		}
+	}
+
+	t.Run("short lines", func(t *testing.T) {
+		messages := splitMessage("header", []string{"a", "b"})
+		if len(messages) != 1 || messages[0] != "header\na\nb" {
+			t.Errorf("got %q", messages)
+		}
+	})
+
+	t.Run("no lines", func(t *testing.T) {
+		messages := splitMessage("", nil)
+		if len(messages) != 0 {
+			t.Errorf("got %q", messages)
+		}
+	})
+
+	t.Run("lines aren't broken", func(t *testing.T) {
+		line := strings.Repeat("я", 1000)
+		lines := []string{line, line, line, line, line}
+		messages := splitMessage("header", lines)
+		checkLimit(t, messages)
+
+		if len(messages) != 2 {
+			t.Fatalf("expected 2 messages, got %d", len(messages))
+		}
+		if messages[1] != line {
+			t.Errorf("lines must be moved to the next message whole")
+		}
+		if strings.Join(messages, "\n") != "header\n"+strings.Join(lines, "\n") {
+			t.Error("text must not change")
+		}
+	})
+}
+
+func TestTruncateEscaped(t *testing.T) {
+	tests := []struct {
+		s     string
+		limit int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"a & b", 9, "a &amp; b"},
+		{"a & b", 8, "a &amp;…"},
+		{"a & b", 7, "a …"},
+		{"a & b", 6, "a …"},
+		{"<tag>", 5, "&lt;…"},
+		{"<tag>", 4, "…"},
+		{"ёёёё", 3, "ёё…"},
+	}
+	for _, tt := range tests {
+		if got := truncateEscaped(tt.s, tt.limit); got != tt.want {
+			t.Errorf("truncateEscaped(%q, %d) = %q, want %q", tt.s, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDigest(t *testing.T) {
+	// checkMarkup проверяет, что сообщение – корректная разметка (все теги закрыты, сущности не разорваны)
+	checkMarkup := func(t *testing.T, msg string) {
+		d := xml.NewDecoder(strings.NewReader("<msg>" + msg + "</msg>"))
+		for {
+			_, err := d.Token()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Fatalf("invalid markup: %s", err)
+			}
+		}
+	}
+
+	long := strings.Repeat("<Go> & \"Rust\" ", 1000)
+	articles := []userdb.PendingArticle{
+		{Title: "Первая статья", Link: "https://habr.com/ru/articles/1/"},
+		{Title: long, Link: "https://habr.com/ru/articles/2/?utm_source=habr&utm_medium=rss"},
+		{Title: "Последняя статья", Link: "https://habr.com/ru/articles/3/"},
+		{Title: "Статья с очень длинной ссылкой", Link: "https://example.com/" + strings.Repeat("a", telegramMessageLimit)},
+	}
+
+	messages := formatDigest(articles)
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(messages))
+	}
+	for i, msg := range messages {
+		if n := utf8.RuneCountInString(msg); n > telegramMessageLimit {
+			t.Errorf("message %d is %d runes long", i, n)
+		}
+		checkMarkup(t, msg)
+	}
+
+	if !strings.HasPrefix(messages[1], "2) <a href='https://habr.com/ru/articles/2/?utm_source=habr&amp;utm_medium=rss'>&lt;Go&gt; &amp; ") ||
+		!strings.HasSuffix(messages[1], "…</a>") {
+		t.Errorf("long title must be truncated inside the link: %.100q…", messages[1])
+	}
+	if !strings.HasSuffix(messages[2], "\n4) Статья с очень длинной ссылкой") {
+		t.Errorf("line without a link: got %.100q", messages[2])
+	}
+}
@@ -0,0 +1,236 @@
+package bot
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mmcdole/gofeed"
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/config"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+// Максимальное количество лент, на которые может подписаться пользователь
+const maxUserFeeds = 20
+
+// Префикс имени источника для пользовательских лент
+const userFeedSourcePrefix = "feed:"
+
+// userFeedSource – RSS/Atom-лента, на которую подписались пользователи
+// Статьи такой ленты рассылаются только подписчикам, без фильтрации по тегам
+type userFeedSource struct {
+	rssSource
+}
+
+func newUserFeedSource(feedURL string) Source {
+	interval := config.Get().Delay
+	return &userFeedSource{rssSource{name: userFeedSourcePrefix + feedURL, url: feedURL, interval: interval}}
+}
+
+// Fetch получает записи ленты за одну попытку через feedClient: ленту указал пользователь,
+// поэтому она может быть медленной или указывать на внутренний адрес
+func (s *userFeedSource) Fetch() ([]*gofeed.Item, error) {
+	feed, err := fetchFeed(feedClient, s.url)
+	if err != nil {
+		return nil, err
+	}
+	return feed.Items, nil
+}
+
+func (s *userFeedSource) Normalize(item *gofeed.Item) article {
+	a := s.rssSource.Normalize(item)
+	a.feed = s.url
+	return a
+}
+
+// userFeeds хранит ленты, для которых зарегистрированы источники
+var userFeeds = struct {
+	sync.Mutex
+	urls map[string]bool
+}{urls: make(map[string]bool)}
+
+// syncUserFeeds регистрирует источники для лент, на которые подписаны пользователи,
+// и удаляет источники лент, на которые больше никто не подписан.
+// Одна лента опрашивается один раз, независимо от количества подписчиков
+func syncUserFeeds() {
+	users, err := userdb.GetActiveUsers()
+	if err != nil {
+		logging.LogMinorError("syncUserFeeds", "попытка получить список пользователей", err)
+		return
+	}
+
+	actual := make(map[string]bool)
+	for _, user := range users {
+		for _, feedURL := range user.Feeds {
+			actual[feedURL] = true
+		}
+	}
+
+	userFeeds.Lock()
+	defer userFeeds.Unlock()
+
+	for feedURL := range userFeeds.urls {
+		if !actual[feedURL] {
+			UnregisterSource(userFeedSourcePrefix + feedURL)
+			delete(userFeeds.urls, feedURL)
+		}
+	}
+
+	for feedURL := range actual {
+		if userFeeds.urls[feedURL] {
+			continue
+		}
+
+		err := RegisterSource(newUserFeedSource(feedURL))
+		if err != nil {
+			logging.LogMinorError("syncUserFeeds", "попытка зарегистрировать ленту "+feedURL, err)
+			continue
+		}
+		userFeeds.urls[feedURL] = true
+	}
+}
+
+// checkFeed проверяет, что по ссылке находится корректная RSS/Atom-лента
+// Возвращает название ленты
+func checkFeed(feedURL string) (string, error) {
+	feed, err := fetchFeed(feedClient, feedURL)
+	if err != nil {
+		return "", err
+	}
+	return feed.Title, nil
+}
+
+// feedsText возвращает список лент в виде текста
+func feedsText(feeds []string) string {
+	if len(feeds) == 0 {
+		return "Список лент пуст"
+	}
+
+	text := "Список лент:\n"
+	for i, feedURL := range feeds {
+		text += strconv.Itoa(i+1) + ") " + feedURL + "\n"
+	}
+	return text
+}
+
+// addFeed подписывает пользователя на RSS/Atom-ленту
+func (bot *Bot) addFeed(msg *tgbotapi.Message) {
+	feedURL := strings.TrimSpace(msg.CommandArguments())
+	u, err := url.Parse(feedURL)
+	if feedURL == "" || strings.ContainsAny(feedURL, " \n") || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		bot.sendErrorToUser("неверный формат ссылки (пример: /add_feed https://blog.golang.org/feed.atom)", msg.Chat.ID)
+		return
+	}
+
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...add_feed",
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+	if len(user.Feeds) >= maxUserFeeds {
+		bot.sendErrorToUser("нельзя подписаться больше, чем на "+strconv.Itoa(maxUserFeeds)+" лент", msg.Chat.ID)
+		return
+	}
+
+	title, err := checkFeed(feedURL)
+	if err != nil {
+		bot.sendErrorToUser("по ссылке не найдена RSS/Atom-лента", msg.Chat.ID)
+		return
+	}
+
+	feeds, err := userdb.AddUserFeed(strconv.FormatInt(msg.Chat.ID, 10), feedURL)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...add_feed",
+			AddInfo:  "попытка добавить ленту"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	go syncUserFeeds()
+
+	text := "Лента «" + title + "» добавлена\n\n" + feedsText(feeds)
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	message.DisableWebPagePreview = true
+	bot.messages <- message
+}
+
+// delFeed отписывает пользователя от RSS/Atom-ленты. Ленту можно указать ссылкой или номером из списка /feeds
+func (bot *Bot) delFeed(msg *tgbotapi.Message) {
+	arg := strings.TrimSpace(msg.CommandArguments())
+	if arg == "" {
+		bot.sendErrorToUser("нужно указать ссылку на ленту или её номер (пример: /del_feed 1)", msg.Chat.ID)
+		return
+	}
+
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...del_feed",
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	feedURL := arg
+	if number, err := strconv.Atoi(arg); err == nil {
+		if number < 1 || number > len(user.Feeds) {
+			bot.sendErrorToUser("ленты с таким номером нет", msg.Chat.ID)
+			return
+		}
+		feedURL = user.Feeds[number-1]
+	}
+
+	feeds, err := userdb.DelUserFeed(strconv.FormatInt(msg.Chat.ID, 10), feedURL)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...del_feed",
+			AddInfo:  "попытка удалить ленту"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	go syncUserFeeds()
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, feedsText(feeds))
+	message.DisableWebPagePreview = true
+	bot.messages <- message
+}
+
+// getFeeds отправляет пользователю список лент, на которые он подписан
+func (bot *Bot) getFeeds(msg *tgbotapi.Message) {
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...feeds",
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, feedsText(user.Feeds))
+	message.DisableWebPagePreview = true
+	bot.messages <- message
+}
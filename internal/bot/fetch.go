@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	// Таймаут запросов к Habr и RSS/Atom-лентам (вместе с чтением ответа)
+	fetchTimeout = 30 * time.Second
+	// Максимальный размер RSS/Atom-ленты или страницы
+	maxResponseSize = 5 << 20
+)
+
+// httpClient – клиент для запросов к Habr
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+// feedClient – клиент для пользовательских лент. Не подключается к внутренним адресам (см. checkPublicAddress)
+var feedClient = &http.Client{
+	Timeout: fetchTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: checkPublicAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: fetchTimeout,
+		MaxIdleConns:          20,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+var errResponseTooLarge = errors.New("response is too large")
+
+// Внутренние сети, к которым нельзя обращаться по ссылкам пользователей
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // "эта" сеть
+		"10.0.0.0/8",     // частная сеть
+		"100.64.0.0/10",  // Carrier-grade NAT
+		"127.0.0.0/8",    // loopback
+		"169.254.0.0/16", // link-local (в том числе метаданные облаков)
+		"172.16.0.0/12",  // частная сеть
+		"192.0.0.0/24",   // служебные адреса IETF
+		"192.168.0.0/16", // частная сеть
+		"198.18.0.0/15",  // тестирование производительности
+		"::1/128",        // loopback
+		"fc00::/7",       // unique local
+		"fe80::/10",      // link-local
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// isPublicIP возвращает true, если ip не принадлежит внутренней сети
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPublicAddress запрещает подключение к внутренним адресам. Вызывается после разрешения DNS-имени
+// для каждого подключения (в том числе при редиректах), поэтому адрес нельзя подменить DNS-записью
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("address %s is not allowed", host)
+	}
+	return nil
+}
+
+// fetch загружает страницу. Ответ больше maxResponseSize считается ошибкой
+func fetch(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseSize {
+		return nil, errResponseTooLarge
+	}
+	return body, nil
+}
+
+// fetchPage загружает HTML-страницу Habr
+func fetchPage(url string) (string, error) {
+	body, err := fetch(httpClient, url)
+	return string(body), err
+}
+
+// fetchFeed загружает RSS/Atom-ленту
+func fetchFeed(client *http.Client, url string) (*gofeed.Feed, error) {
+	body, err := fetch(client, url)
+	if err != nil {
+		return nil, err
+	}
+	return gofeed.NewParser().ParseString(string(body))
+}
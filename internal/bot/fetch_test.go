@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"224.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestFeedClientRejectsInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<rss></rss>"))
+	}))
+	defer server.Close()
+
+	_, err := fetch(feedClient, server.URL)
+	if err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Fatalf("expected the loopback address to be rejected, got %v", err)
+	}
+
+}
+
+func TestFeedClientRejectsRedirectsToInternalAddresses(t *testing.T) {
+	var hits int
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	// Транспорт feedClient, в котором только публичный адрес feed.example ведёт на тестовый сервер.
+	// Остальные адреса, в том числе адрес редиректа, проверяются исходным DialContext
+	transport := feedClient.Transport.(*http.Transport).Clone()
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "feed.example:80" {
+			return (&net.Dialer{}).DialContext(ctx, network, redirect.Listener.Addr().String())
+		}
+		return dial(ctx, network, addr)
+	}
+	client := &http.Client{Timeout: feedClient.Timeout, Transport: transport}
+
+	_, err := fetch(client, "http://feed.example/rss")
+	if hits != 1 {
+		t.Fatalf("the feed must be requested once, got %d requests", hits)
+	}
+	if err == nil || !strings.Contains(err.Error(), "address 169.254.169.254 is not allowed") {
+		t.Fatalf("expected the redirect to the link-local address to be rejected, got %v", err)
+	}
+}
+
+func TestFetchLimitsResponseSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			w.Write(make([]byte, maxResponseSize+1))
+			return
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(make([]byte, maxResponseSize))
+	}))
+	defer server.Close()
+
+	if _, err := fetch(httpClient, server.URL+"/large"); err != errResponseTooLarge {
+		t.Errorf("expected errResponseTooLarge, got %v", err)
+	}
+	if _, err := fetch(httpClient, server.URL+"/missing"); err == nil {
+		t.Error("expected an error for 404")
+	}
+	body, err := fetch(httpClient, server.URL)
+	if err != nil || len(body) != maxResponseSize {
+		t.Errorf("expected %d bytes, got %d (%v)", maxResponseSize, len(body), err)
+	}
+}
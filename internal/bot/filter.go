@@ -0,0 +1,288 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+/*
+*	Язык фильтров по тегам
+*
+*	expr   := and { OR and }
+*	and    := unary { [AND] unary }   – AND можно не писать: "go -вакансии" == "go AND NOT вакансии"
+*	unary  := NOT unary | -tag | ( expr ) | tag
+*
+*	Приоритет: NOT > AND > OR. Ключевые слова не зависят от регистра
+*
+*	Исключения верхнего уровня (NOT и -тег, соединённые с остальным фильтром через AND) действуют и на теги
+*	пользователя: если статья подходит под остальную часть фильтра, но содержит исключённый тег, она
+*	не отправляется, даже если совпал тег из /tags. Например, при теге go и фильтре "go -вакансии"
+*	вакансии по go не приходят
+ */
+
+// Максимальная длина фильтра
+const maxFilterLength = 200
+
+// Максимальное количество фильтров у пользователя
+const maxUserFilters = 10
+
+// filterNode – узел дерева разбора фильтра
+type filterNode interface {
+	match(tags map[string]bool) bool
+}
+
+type tagFilter string
+
+func (f tagFilter) match(tags map[string]bool) bool {
+	return tags[string(f)]
+}
+
+type notFilter struct {
+	node filterNode
+}
+
+func (f notFilter) match(tags map[string]bool) bool {
+	return !f.node.match(tags)
+}
+
+type andFilter struct {
+	left, right filterNode
+}
+
+func (f andFilter) match(tags map[string]bool) bool {
+	return f.left.match(tags) && f.right.match(tags)
+}
+
+type orFilter struct {
+	left, right filterNode
+}
+
+func (f orFilter) match(tags map[string]bool) bool {
+	return f.left.match(tags) || f.right.match(tags)
+}
+
+// filterToken – лексема фильтра
+type filterToken struct {
+	text string
+	pos  int // позиция в строке (в символах, с 1)
+}
+
+// tokenizeFilter разбивает фильтр на лексемы: скобки и слова
+func tokenizeFilter(s string) []filterToken {
+	var tokens []filterToken
+	var word []rune
+	wordPos := 0
+
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, filterToken{text: string(word), pos: wordPos})
+			word = nil
+		}
+	}
+
+	for i, r := range []rune(s) {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, filterToken{text: string(r), pos: i + 1})
+		default:
+			if len(word) == 0 {
+				wordPos = i + 1
+			}
+			word = append(word, r)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// filterParser – рекурсивный парсер фильтров
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// parseFilter разбирает фильтр. Ошибка содержит понятное пользователю описание проблемы
+func parseFilter(s string) (filterNode, error) {
+	if len([]rune(s)) > maxFilterLength {
+		return nil, fmt.Errorf("фильтр не может быть длиннее %d символов", maxFilterLength)
+	}
+
+	p := filterParser{tokens: tokenizeFilter(strings.ToLower(s))}
+	if len(p.tokens) == 0 {
+		return nil, errors.New("фильтр не может быть пустым")
+	}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("неожиданное «%s» (позиция %d)", tok.text, tok.pos)
+	}
+	return node, nil
+}
+
+func (p *filterParser) peek() (filterToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.text != "or" {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.text == "or" || tok.text == ")" {
+			return left, nil
+		}
+		if tok.text == "and" {
+			p.pos++
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, errors.New("фильтр неожиданно закончился (не хватает тега)")
+	}
+
+	switch {
+	case tok.text == "not":
+		p.pos++
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{node}, nil
+	case tok.text == "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, ok := p.peek()
+		if !ok || closing.text != ")" {
+			return nil, fmt.Errorf("не закрыта скобка (позиция %d)", tok.pos)
+		}
+		p.pos++
+		return node, nil
+	case tok.text == ")" || tok.text == "and" || tok.text == "or":
+		return nil, fmt.Errorf("неожиданное «%s» (позиция %d)", tok.text, tok.pos)
+	case strings.HasPrefix(tok.text, "-"):
+		p.pos++
+		tag := strings.TrimPrefix(tok.text, "-")
+		if tag == "" {
+			return nil, fmt.Errorf("после «-» должен идти тег (позиция %d)", tok.pos)
+		}
+		return notFilter{tagFilter(tag)}, nil
+	default:
+		p.pos++
+		return tagFilter(tok.text), nil
+	}
+}
+
+// Кэш разобранных фильтров (фильтры проверяются для каждой статьи и каждого пользователя)
+var parsedFilters = struct {
+	sync.RWMutex
+	m map[string]filterNode
+}{m: make(map[string]filterNode)}
+
+// getFilter возвращает разобранный фильтр из кэша. ok == false, если фильтр некорректный
+func getFilter(filter string) (node filterNode, ok bool) {
+	parsedFilters.RLock()
+	node, ok = parsedFilters.m[filter]
+	parsedFilters.RUnlock()
+	if ok {
+		return node, true
+	}
+
+	node, err := parseFilter(filter)
+	if err != nil {
+		return nil, false
+	}
+
+	parsedFilters.Lock()
+	parsedFilters.m[filter] = node
+	parsedFilters.Unlock()
+	return node, true
+}
+
+// matchFilter проверяет, подходят ли теги статьи под фильтр. Некорректный фильтр ничему не соответствует
+func matchFilter(filter string, tags map[string]bool) bool {
+	node, ok := getFilter(filter)
+	if !ok {
+		return false
+	}
+	return node.match(tags)
+}
+
+// conjuncts разбивает фильтр на части, соединённые через AND
+func conjuncts(node filterNode) []filterNode {
+	if and, ok := node.(andFilter); ok {
+		return append(conjuncts(and.left), conjuncts(and.right)...)
+	}
+	return []filterNode{node}
+}
+
+// excludedByFilter проверяет, исключает ли фильтр статью: статья подходит под все части фильтра,
+// кроме исключений верхнего уровня, и подходит хотя бы под одно исключение (см. описание языка фильтров)
+func excludedByFilter(filter string, tags map[string]bool) bool {
+	node, ok := getFilter(filter)
+	if !ok {
+		return false
+	}
+
+	var excluded bool
+	for _, part := range conjuncts(node) {
+		if not, ok := part.(notFilter); ok {
+			if not.node.match(tags) {
+				excluded = true
+			}
+			continue
+		}
+		if !part.match(tags) {
+			return false
+		}
+	}
+	return excluded
+}
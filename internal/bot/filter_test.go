@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+func tagSet(tags ...string) map[string]bool {
+	m := make(map[string]bool)
+	for _, tag := range tags {
+		m[tag] = true
+	}
+	return m
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		tags   []string
+		match  bool
+	}{
+		// NOT > AND > OR
+		{"a OR b AND c", []string{"a"}, true},
+		{"a OR b AND c", []string{"b"}, false},
+		{"a OR b AND c", []string{"b", "c"}, true},
+		{"NOT a AND b", []string{"b"}, true},
+		{"NOT a AND b", []string{"a", "b"}, false},
+		{"NOT a OR b", []string{"a", "b"}, true},
+		{"NOT a OR b", []string{"a"}, false},
+		// Скобки
+		{"(a OR b) AND c", []string{"a"}, false},
+		{"(a OR b) AND c", []string{"b", "c"}, true},
+		{"NOT (a OR b)", []string{"b"}, false},
+		{"NOT (a OR b)", []string{"c"}, true},
+		{"((a))", []string{"a"}, true},
+		// AND можно не писать, -тег – сокращение для NOT тег
+		{"go -вакансии", []string{"go"}, true},
+		{"go -вакансии", []string{"go", "вакансии"}, false},
+		{"a b OR c", []string{"c"}, true},
+		{"a b OR c", []string{"a"}, false},
+		// Регистр не важен
+		{"Go and NOT Вакансии", []string{"go"}, true},
+		{"GO or rust", []string{"rust"}, true},
+	}
+
+	for _, tt := range tests {
+		node, err := parseFilter(tt.filter)
+		if err != nil {
+			t.Errorf("parseFilter(%q): unexpected error: %s", tt.filter, err)
+			continue
+		}
+		if got := node.match(tagSet(tt.tags...)); got != tt.match {
+			t.Errorf("%q with tags %v: got %v, want %v", tt.filter, tt.tags, got, tt.match)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		filter string
+		err    string
+	}{
+		{"", "фильтр не может быть пустым"},
+		{"   ", "фильтр не может быть пустым"},
+		{"go AND", "фильтр неожиданно закончился"},
+		{"NOT", "фильтр неожиданно закончился"},
+		{"(go OR rust", "не закрыта скобка (позиция 1)"},
+		{"go AND (rust OR (c", "не закрыта скобка (позиция 17)"},
+		{"go )", "неожиданное «)» (позиция 4)"},
+		{"OR go", "неожиданное «or» (позиция 1)"},
+		{"go AND OR rust", "неожиданное «or» (позиция 8)"},
+		{"go -", "после «-» должен идти тег (позиция 4)"},
+		{"тег ()", "неожиданное «)» (позиция 6)"},
+		{strings.Repeat("a", maxFilterLength+1), "не может быть длиннее"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseFilter(tt.filter)
+		if err == nil {
+			t.Errorf("parseFilter(%q): expected an error", tt.filter)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseFilter(%q): got error %q, want %q", tt.filter, err, tt.err)
+		}
+	}
+}
+
+func TestShouldSendFiltersAndTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    userdb.User
+		tags    []string
+		receive bool
+	}{
+		{"no tags and filters", userdb.User{}, []string{"go"}, true},
+		{"tag", userdb.User{Tags: []string{"go"}}, []string{"go"}, true},
+		{"other tag", userdb.User{Tags: []string{"go"}}, []string{"rust"}, false},
+		{"filter", userdb.User{Filters: []string{"kubernetes AND security"}}, []string{"kubernetes", "security"}, true},
+		{"filter doesn't match", userdb.User{Filters: []string{"kubernetes AND security"}}, []string{"kubernetes"}, false},
+
+		// Исключение в фильтре сильнее совпавшего тега
+		{"tag excluded by filter", userdb.User{Tags: []string{"go"}, Filters: []string{"go AND -вакансии"}},
+			[]string{"go", "вакансии"}, false},
+		{"tag not excluded", userdb.User{Tags: []string{"go"}, Filters: []string{"go AND -вакансии"}},
+			[]string{"go"}, true},
+		{"exclusion applies only with the rest of the filter", userdb.User{Tags: []string{"python"}, Filters: []string{"go -вакансии"}},
+			[]string{"python", "вакансии"}, true},
+		{"pure exclusion", userdb.User{Tags: []string{"go"}, Filters: []string{"-вакансии"}},
+			[]string{"go", "вакансии"}, false},
+		{"NOT in parentheses", userdb.User{Tags: []string{"go"}, Filters: []string{"go NOT (вакансии OR курсы)"}},
+			[]string{"go", "курсы"}, false},
+		// Отрицание внутри OR – не исключение верхнего уровня
+		{"NOT inside OR", userdb.User{Tags: []string{"go"}, Filters: []string{"rust OR -вакансии"}},
+			[]string{"go", "вакансии"}, true},
+		{"invalid filter is ignored", userdb.User{Tags: []string{"go"}, Filters: []string{"go AND"}},
+			[]string{"go"}, true},
+	}
+
+	for _, tt := range tests {
+		got := shouldSend(tt.user, article{tags: tt.tags})
+		if got != tt.receive {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.receive)
+		}
+	}
+}
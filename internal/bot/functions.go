@@ -40,7 +40,6 @@ func formatString(s string, args map[string]string) string {
 // getRSS возвращает gofeed.Feed
 // Если количество неудачных попыток получить RSS-ленту превысило лимит, то возвращается ошибка
 func getRSS(source string) (*gofeed.Feed, error) {
-	parser := gofeed.NewParser()
 	var err error
 	var feed *gofeed.Feed
 
@@ -48,7 +47,7 @@ func getRSS(source string) (*gofeed.Feed, error) {
 	const limit = 10
 	i := 0
 	for ; i < limit; i++ {
-		feed, err = parser.ParseURL(source)
+		feed, err = fetchFeed(httpClient, source)
 		if err == nil {
 			break
 		}
@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+const (
+	// Количество результатов в одном ответе на inline-запрос (ограничение Telegram – 50)
+	inlineResultsPerPage = 20
+	// Сколько секунд Telegram может кэшировать ответ на inline-запрос
+	inlineCacheTime = 300
+	// Максимальная длина описания результата
+	inlineDescriptionLength = 100
+)
+
+// answerInlineQuery ищет статьи по inline-запросу (@bot запрос) в архиве.
+// Если в архиве ничего не нашлось, статьи ищутся через RSS-ленту поиска Habr
+func (bot *Bot) answerInlineQuery(query *tgbotapi.InlineQuery) {
+	logging.LogRequest(logging.RequestData{Command: "inline " + query.Query, Username: query.From.UserName, ID: int64(query.From.ID)})
+
+	inline := tgbotapi.InlineConfig{InlineQueryID: query.ID, CacheTime: inlineCacheTime, Results: []interface{}{}}
+
+	text := strings.Join(strings.Fields(query.Query), " ")
+	if text == "" || utf8.RuneCountInString(text) > maxSearchQueryLength {
+		bot.sendInlineAnswer(inline)
+		return
+	}
+
+	offset, _ := strconv.Atoi(query.Offset)
+
+	articles, err := searchArchive(text)
+	if err != nil {
+		logging.LogMinorError("answerInlineQuery", "попытка найти статьи по запросу "+text, err)
+	}
+	if len(articles) == 0 {
+		articles, err = searchHabr(text)
+		if err != nil {
+			logging.LogMinorError("answerInlineQuery", "попытка найти статьи на Habr по запросу "+text, err)
+		}
+	}
+
+	for i := offset; i < len(articles) && i < offset+inlineResultsPerPage; i++ {
+		inline.Results = append(inline.Results, inlineResult(strconv.Itoa(i), articles[i]))
+	}
+	if offset+inlineResultsPerPage < len(articles) {
+		inline.NextOffset = strconv.Itoa(offset + inlineResultsPerPage)
+	}
+
+	bot.sendInlineAnswer(inline)
+}
+
+// sendInlineAnswer отправляет ответ на inline-запрос
+func (bot *Bot) sendInlineAnswer(inline tgbotapi.InlineConfig) {
+	_, err := bot.botAPI.AnswerInlineQuery(inline)
+	if err != nil {
+		logging.LogMinorError("sendInlineAnswer", "попытка ответить на inline-запрос", err)
+	}
+}
+
+// inlineResult возвращает статью в виде результата inline-запроса
+func inlineResult(id string, a userdb.ArchivedArticle) tgbotapi.InlineQueryResultArticle {
+	text := formatString(messageText, map[string]string{"title": html.EscapeString(a.Title), "link": a.Link})
+	result := tgbotapi.NewInlineQueryResultArticleHTML(id, a.Title, text)
+	result.URL = a.Link
+	result.HideURL = true
+
+	var description []string
+	if !a.Published.IsZero() {
+		description = append(description, a.Published.Format("02.01.2006"))
+	}
+	if len(a.Tags) > 0 {
+		description = append(description, strings.Join(a.Tags, ", "))
+	}
+	result.Description = strings.Join(description, " · ")
+	if utf8.RuneCountInString(result.Description) > inlineDescriptionLength {
+		result.Description = string([]rune(result.Description)[:inlineDescriptionLength]) + "…"
+	}
+
+	return result
+}
+
+// searchHabr ищет статьи через RSS-ленту поиска Habr. Лента запрашивается один раз: на inline-запрос нужно ответить быстро
+func searchHabr(query string) ([]userdb.ArchivedArticle, error) {
+	feed, err := fetchFeed(httpClient, fmt.Sprintf(searchHabrArticlesURL, url.QueryEscape(query)))
+	if err != nil {
+		return nil, err
+	}
+
+	articles := make([]userdb.ArchivedArticle, 0, len(feed.Items))
+	for _, item := range feed.Items {
+		a := userdb.ArchivedArticle{Title: item.Title, Link: item.Link, AddedAt: time.Now()}
+		if item.PublishedParsed != nil {
+			a.Published = *item.PublishedParsed
+		}
+		for _, tag := range item.Categories {
+			a.Tags = append(a.Tags, normalizeTag(tag))
+		}
+		articles = append(articles, a)
+	}
+	return articles, nil
+}
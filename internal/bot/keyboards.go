@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"html"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+const (
+	// Максимальное количество кнопок подписки на теги под статьёй
+	maxArticleTagButtons = 6
+	// Максимальное количество кнопок удаления тегов в /tags
+	maxTagButtons = 50
+)
+
+// articleKeyboard возвращает кнопки под статьёй: сохранение в закладки и подписка на теги статьи,
+// на которые пользователь ещё не подписан. Кнопки тегов есть только у статей Habr
+func articleKeyboard(link string, tags, userTags []string) interface{} {
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("🔖 Сохранить", callbackSave+bookmarkKey(link))),
+	}
+
+	postID := getPostID(link)
+	if postID == "" {
+		tags = nil
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	var counter int
+	for _, tag := range tags {
+		data := callbackTag + postID + ":" + tag
+		// Telegram ограничивает длину данных кнопки
+		if len(data) > maxCallbackData || contains(userTags, tag) || counter == maxArticleTagButtons {
+			continue
+		}
+		counter++
+
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("➕ "+tag, data))
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// archivedKeyboard возвращает кнопки под статьёй, теги которой берутся из архива
+func archivedKeyboard(link string, userTags []string) interface{} {
+	postID := getPostID(link)
+	if postID == "" {
+		return articleKeyboard(link, nil, userTags)
+	}
+
+	a, _, err := userdb.GetArchived(postID)
+	if err != nil {
+		logging.LogMinorError("archivedKeyboard", "попытка получить статью "+postID, err)
+	}
+	return articleKeyboard(link, a.Tags, userTags)
+}
+
+// listKeyboard возвращает кнопки сохранения в закладки для списка статей (по номерам в списке)
+func listKeyboard(links []string) interface{} {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for i, link := range links {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("🔖 "+strconv.Itoa(i+1), callbackSave+bookmarkKey(link)))
+		if len(row) == 5 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// statusText возвращает список тегов и состояние рассылки пользователя
+func statusText(user userdb.User) string {
+	var text string
+	if len(user.Tags) == 0 {
+		text = "Список тегов пуст"
+	} else {
+		text = "Список тегов:\n* "
+		text += html.EscapeString(strings.Join(user.Tags, "\n* "))
+	}
+
+	text += "\n\n📬 Рассылка: "
+
+	if user.Mailout {
+		text += "осуществляется"
+	} else {
+		text += "не осуществляется"
+	}
+
+	return text
+}
+
+// tagsKeyboard возвращает кнопки для удаления тегов. Если тегов нет – nil
+func tagsKeyboard(tags []string) *tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for i, tag := range tags {
+		data := callbackUntag + tag
+		if len(data) > maxCallbackData || i == maxTagButtons {
+			continue
+		}
+
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("❌ "+tag, data))
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+// subscribeFromCallback подписывает пользователя на тег статьи и убирает кнопку этого тега из-под статьи
+func (bot *Bot) subscribeFromCallback(query *tgbotapi.CallbackQuery, data string) {
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		bot.answerCallback(query, "Неверный тег")
+		return
+	}
+	postID, tag := parts[0], parts[1]
+	id := strconv.FormatInt(query.Message.Chat.ID, 10)
+
+	userTags, err := userdb.AddUserTags(id, []string{tag})
+	if err != nil {
+		logging.LogMinorError("subscribeFromCallback", "UserID: "+id+" Tag: "+tag, err)
+		bot.answerCallback(query, "Что-то пошло не так")
+		return
+	}
+
+	a, ok, err := userdb.GetArchived(postID)
+	if err == nil && ok {
+		bot.editReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, articleKeyboard(a.Link, a.Tags, userTags))
+	}
+
+	bot.answerCallback(query, "Вы подписались на тег "+tag)
+}
+
+// unsubscribeFromCallback удаляет тег и обновляет список тегов в том же сообщении
+func (bot *Bot) unsubscribeFromCallback(query *tgbotapi.CallbackQuery, tag string) {
+	id := strconv.FormatInt(query.Message.Chat.ID, 10)
+
+	_, err := userdb.DelUserTags(id, []string{tag})
+	if err != nil {
+		logging.LogMinorError("unsubscribeFromCallback", "UserID: "+id+" Tag: "+tag, err)
+		bot.answerCallback(query, "Что-то пошло не так")
+		return
+	}
+
+	user, err := userdb.GetUser(id)
+	if err != nil {
+		logging.LogMinorError("unsubscribeFromCallback", "попытка получить данные пользователя "+id, err)
+		bot.answerCallback(query, "Что-то пошло не так")
+		return
+	}
+
+	bot.editMessage(query.Message.Chat.ID, query.Message.MessageID, statusText(user), tagsKeyboard(user.Tags))
+	bot.answerCallback(query, "Тег "+tag+" удалён")
+}
@@ -0,0 +1,385 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+const (
+	// Максимальное суммарное количество ключевых слов и регулярных выражений у пользователя
+	maxUserKeywords = 20
+	// Максимальная длина регулярного выражения
+	maxRegexpLength = 100
+	// Максимальное количество инструкций скомпилированного регулярного выражения
+	maxRegexpProgramSize = 2000
+	// Минимальная длина основы слова, после которой можно отбрасывать окончание
+	minStemLength = 3
+)
+
+// Окончания русских слов (отсортированы по убыванию длины). Используются для сопоставления по основе слова,
+// чтобы ключевое слово "база" находило "базы", "базами" и т.д.
+var russianEndings = []string{
+	"ями", "ами", "ого", "его", "ому", "ему", "ыми", "ими", "ией",
+	"ой", "ей", "ий", "ый", "ая", "яя", "ое", "ее", "ые", "ие", "ов", "ев",
+	"ам", "ям", "ах", "ях", "ом", "ем", "ую", "юю", "ия", "ии",
+	"а", "я", "о", "е", "ы", "и", "у", "ю", "ь", "й",
+}
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// stripHTML удаляет html-теги и заменяет html-сущности
+func stripHTML(s string) string {
+	s = htmlTagRegexp.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// isCyrillic проверяет, состоит ли слово только из кириллицы
+func isCyrillic(word string) bool {
+	for _, r := range word {
+		if !unicode.Is(unicode.Cyrillic, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// stem возвращает основу слова: для русских слов отбрасывается окончание
+func stem(word string) string {
+	if !isCyrillic(word) {
+		return word
+	}
+
+	for _, ending := range russianEndings {
+		if strings.HasSuffix(word, ending) && utf8.RuneCountInString(word)-utf8.RuneCountInString(ending) >= minStemLength {
+			return strings.TrimSuffix(word, ending)
+		}
+	}
+	return word
+}
+
+// splitWords разбивает текст на слова в нижнем регистре
+func splitWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchKeyword проверяет, есть ли ключевое слово в тексте. Русские слова сравниваются по основе
+// ("база" находит "базами"), остальные – целиком ("go" не находит "google")
+func matchKeyword(keyword string, words []string) bool {
+	if !isCyrillic(keyword) {
+		for _, word := range words {
+			if word == keyword {
+				return true
+			}
+		}
+		return false
+	}
+
+	keywordStem := stem(keyword)
+	for _, word := range words {
+		if strings.HasPrefix(word, keywordStem) {
+			return true
+		}
+	}
+	return false
+}
+
+// notIn возвращает элементы items, которых нет в existing
+func notIn(items, existing []string) []string {
+	m := make(map[string]bool, len(existing))
+	for _, item := range existing {
+		m[item] = true
+	}
+
+	var result []string
+	for _, item := range items {
+		if !m[item] {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Кэш скомпилированных регулярных выражений
+var compiledRegexps = struct {
+	sync.RWMutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// compileRegexp компилирует регулярное выражение без учёта регистра, проверяя ограничения на его размер
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	compiledRegexps.RLock()
+	re, ok := compiledRegexps.m[pattern]
+	compiledRegexps.RUnlock()
+	if ok {
+		return re, nil
+	}
+
+	if utf8.RuneCountInString(pattern) > maxRegexpLength {
+		return nil, fmt.Errorf("регулярное выражение не может быть длиннее %d символов", maxRegexpLength)
+	}
+
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, errors.New("некорректное регулярное выражение: " + err.Error())
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, errors.New("некорректное регулярное выражение: " + err.Error())
+	}
+	if len(prog.Inst) > maxRegexpProgramSize {
+		return nil, errors.New("регулярное выражение слишком сложное")
+	}
+
+	re, err = regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, errors.New("некорректное регулярное выражение: " + err.Error())
+	}
+
+	compiledRegexps.Lock()
+	compiledRegexps.m[pattern] = re
+	compiledRegexps.Unlock()
+
+	return re, nil
+}
+
+// matchKeywords проверяет, подходит ли заголовок или описание статьи под ключевые слова
+// или регулярные выражения пользователя
+func matchKeywords(user userdb.User, a article) bool {
+	if len(user.Keywords) == 0 && len(user.Regexps) == 0 {
+		return false
+	}
+
+	text := a.title + "\n" + a.description
+
+	words := splitWords(text)
+	for _, keyword := range user.Keywords {
+		if matchKeyword(keyword, words) {
+			return true
+		}
+	}
+
+	for _, pattern := range user.Regexps {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			continue
+		}
+		if re.MatchString(text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keywordsText возвращает список ключевых слов и регулярных выражений в виде текста
+func keywordsText(keywords, regexps []string) string {
+	var text string
+	if len(keywords) == 0 {
+		text = "Список ключевых слов пуст"
+	} else {
+		text = "Ключевые слова:\n* " + strings.Join(keywords, "\n* ")
+	}
+
+	if len(regexps) > 0 {
+		text += "\n\nРегулярные выражения:\n"
+		for i, pattern := range regexps {
+			text += strconv.Itoa(i+1) + ") " + pattern + "\n"
+		}
+	}
+	return text
+}
+
+// addKeywords добавляет ключевые слова, которые прислал пользователь
+func (bot *Bot) addKeywords(msg *tgbotapi.Message) {
+	newKeywords := toSet(splitWords(msg.CommandArguments()))
+	if len(newKeywords) == 0 {
+		bot.sendErrorToUser("список ключевых слов не может быть пустым", msg.Chat.ID)
+		return
+	}
+
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...add_keyword",
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+	// Уже добавленные слова не учитываются в ограничении
+	if len(user.Keywords)+len(user.Regexps)+len(notIn(newKeywords, user.Keywords)) > maxUserKeywords {
+		bot.sendErrorToUser("нельзя добавить больше "+strconv.Itoa(maxUserKeywords)+" ключевых слов и регулярных выражений", msg.Chat.ID)
+		return
+	}
+
+	keywords, err := userdb.AddUserKeywords(strconv.FormatInt(msg.Chat.ID, 10), newKeywords)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...add_keyword",
+			AddInfo:  "попытка добавить ключевые слова"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, keywordsText(keywords, user.Regexps))
+	bot.messages <- message
+}
+
+// delKeywords удаляет ключевые слова, которые прислал пользователь
+func (bot *Bot) delKeywords(msg *tgbotapi.Message) {
+	keywordsForDel := toSet(splitWords(msg.CommandArguments()))
+	if len(keywordsForDel) == 0 {
+		bot.sendErrorToUser("список ключевых слов не может быть пустым", msg.Chat.ID)
+		return
+	}
+
+	keywords, err := userdb.DelUserKeywords(strconv.FormatInt(msg.Chat.ID, 10), keywordsForDel)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...del_keyword",
+			AddInfo:  "попытка удалить ключевые слова"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...del_keyword",
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, keywordsText(keywords, user.Regexps))
+	bot.messages <- message
+}
+
+// addRegexp добавляет регулярное выражение (регистр не учитывается)
+func (bot *Bot) addRegexp(msg *tgbotapi.Message) {
+	pattern := strings.TrimSpace(msg.CommandArguments())
+	if pattern == "" {
+		bot.sendErrorToUser("регулярное выражение не может быть пустым", msg.Chat.ID)
+		return
+	}
+	if _, err := compileRegexp(pattern); err != nil {
+		bot.sendErrorToUser(err.Error(), msg.Chat.ID)
+		return
+	}
+
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...add_regexp",
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+	if len(user.Keywords)+len(user.Regexps)+len(notIn([]string{pattern}, user.Regexps)) > maxUserKeywords {
+		bot.sendErrorToUser("нельзя добавить больше "+strconv.Itoa(maxUserKeywords)+" ключевых слов и регулярных выражений", msg.Chat.ID)
+		return
+	}
+
+	regexps, err := userdb.AddUserRegexp(strconv.FormatInt(msg.Chat.ID, 10), pattern)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...add_regexp",
+			AddInfo:  "попытка добавить регулярное выражение"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, keywordsText(user.Keywords, regexps))
+	bot.messages <- message
+}
+
+// delRegexp удаляет регулярное выражение по его номеру из списка /keywords
+func (bot *Bot) delRegexp(msg *tgbotapi.Message) {
+	number, err := strconv.Atoi(strings.TrimSpace(msg.CommandArguments()))
+	if err != nil {
+		bot.sendErrorToUser("нужно указать номер регулярного выражения (пример: /del_regexp 1)", msg.Chat.ID)
+		return
+	}
+
+	regexps, err := userdb.DelUserRegexp(strconv.FormatInt(msg.Chat.ID, 10), number)
+	if err == userdb.ErrNoSuchElement {
+		bot.sendErrorToUser("регулярного выражения с таким номером нет", msg.Chat.ID)
+		return
+	}
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...del_regexp",
+			AddInfo:  "попытка удалить регулярное выражение"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...del_regexp",
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, keywordsText(user.Keywords, regexps))
+	bot.messages <- message
+}
+
+// getKeywords отправляет пользователю список ключевых слов и регулярных выражений
+func (bot *Bot) getKeywords(msg *tgbotapi.Message) {
+	user, err := userdb.GetUser(strconv.FormatInt(msg.Chat.ID, 10))
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...keywords",
+			AddInfo:  "попытка получить данные пользователя"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	message := tgbotapi.NewMessage(msg.Chat.ID, keywordsText(user.Keywords, user.Regexps))
+	bot.messages <- message
+}
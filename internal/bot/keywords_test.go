@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word, stem string
+	}{
+		{"база", "баз"},
+		{"базами", "баз"},
+		{"данных", "данных"},
+		{"новый", "нов"},
+		{"кот", "кот"},
+		// Основа не короче minStemLength
+		{"юла", "юла"},
+		// Не кириллица – без изменений
+		{"postgres", "postgres"},
+		{"go1", "go1"},
+		{"goя", "goя"},
+	}
+
+	for _, tt := range tests {
+		if got := stem(tt.word); got != tt.stem {
+			t.Errorf("stem(%q) = %q, want %q", tt.word, got, tt.stem)
+		}
+	}
+}
+
+func TestMatchKeyword(t *testing.T) {
+	tests := []struct {
+		keyword string
+		text    string
+		match   bool
+	}{
+		// Русские слова – по основе
+		{"база", "Индексы в базах данных", true},
+		{"база", "Работа с базами", true},
+		{"база", "Базы", true},
+		{"база", "Основы SQL", false},
+		// Остальные – целиком
+		{"go", "Новое в Go 1.12", true},
+		{"go", "Как Google ищет", false},
+		{"go", "ГОСТ и gost", false},
+		{"go", "gorilla/mux", false},
+		{"rust", "Привет, Rustam", false},
+		{"rust", "rust-analyzer", true},
+		{"postgres", "PostgreSQL 12", false},
+		{"postgresql", "PostgreSQL 12", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchKeyword(tt.keyword, splitWords(tt.text)); got != tt.match {
+			t.Errorf("matchKeyword(%q, %q) = %v, want %v", tt.keyword, tt.text, got, tt.match)
+		}
+	}
+}
+
+func TestNotIn(t *testing.T) {
+	got := notIn([]string{"go", "rust", "база"}, []string{"go", "база", "c"})
+	if len(got) != 1 || got[0] != "rust" {
+		t.Errorf("notIn: got %v, want [rust]", got)
+	}
+	if got := notIn([]string{"go"}, []string{"go"}); len(got) != 0 {
+		t.Errorf("notIn: got %v, want []", got)
+	}
+}
+
+func TestCompileRegexp(t *testing.T) {
+	re, err := compileRegexp(`go\s?1\.\d+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !re.MatchString("Вышел GO 1.13") {
+		t.Error("the regexp must be case-insensitive")
+	}
+
+	tests := []struct {
+		pattern string
+		err     string
+	}{
+		{strings.Repeat("a", maxRegexpLength+1), "не может быть длиннее"},
+		{"(go", "некорректное регулярное выражение"},
+		{"a{1001}", "некорректное регулярное выражение"},
+		{"(abcd){600}", "слишком сложное"},
+	}
+	for _, tt := range tests {
+		_, err := compileRegexp(tt.pattern)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("compileRegexp(%q): got %v, want error %q", tt.pattern, err, tt.err)
+		}
+	}
+
+	// Длина считается в символах, а не в байтах
+	if _, err := compileRegexp(strings.Repeat("я", maxRegexpLength)); err != nil {
+		t.Errorf("unexpected error for %d cyrillic runes: %s", maxRegexpLength, err)
+	}
+}
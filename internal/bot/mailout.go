@@ -1,16 +1,11 @@
 package bot
 
 import (
-	"encoding/json"
-	"errors"
-	"io/ioutil"
-	"sort"
+	"context"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
-	"github.com/mmcdole/gofeed"
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 
 	"github.com/ShoshinNikita/habrahabr-bot-go/internal/config"
@@ -18,255 +13,201 @@ import (
 	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
 )
 
-// smartQueue хранит список уникальных строк (url) в виде очереди
-// Максимальный размер равен size. При переполнении первый элемент удаляется
-type smartQueue struct {
-	queue []string
-	size  int
-}
+// Час (по часовому поясу пользователя), в который рассылаются лучшие статьи
+const bestArticlesHour = 21
 
-// newSmartQueue возвращает новый объект
-func newSmartQueue(size int, items []string) smartQueue {
-	q := smartQueue{}
-	q.size = size
-	q.queue = make([]string, len(items))
-	copy(q.queue, items)
-	return q
-}
+// mailoutBestArticles рассылает списки лучших статей пользователям, у которых сейчас bestArticlesHour.
+// Параметры списка каждый пользователь настраивает командой /best_mailout
+func (bot *Bot) mailoutBestArticles() {
+	allUsers, err := userdb.GetActiveUsers()
+	if err != nil {
+		logging.LogMinorError("mailoutBestArticles", "попытка получить список пользователей", err)
+		return
+	}
 
-// add добавляет в очередь только уникальные строки (ссылки на статьи)
-func (m *smartQueue) add(s string) {
-	shouldAdd := true
-	// Проверяем уникальность строки
-	for _, q := range m.queue {
-		if q == s {
-			shouldAdd = false
-			break
+	now := time.Now()
+	feeds := bestFeeds{}
+	for _, user := range allUsers {
+		local := now.In(userLocation(user))
+		if !user.Mailout || local.Hour() != bestArticlesHour || isSameDay(user.BestSentAt, local) {
+			continue
+		}
+		opts, ok := userBestOptions(user)
+		if !ok {
+			continue
 		}
-	}
 
-	if shouldAdd {
-		m.queue = append(m.queue, s)
-		if len(m.queue) > m.size {
-			m.queue = append([]string{}, m.queue[1:]...)
+		items, err := getBestArticles(feeds, opts, user.Tags)
+		if err != nil {
+			logging.LogMinorError("mailoutBestArticles", "попытка получить RSS-ленту лучших статей", err)
+			continue
+		}
+		// Пустые списки не присылаются
+		if len(items) > 0 {
+			message := tgbotapi.NewMessage(user.ID, formatBest(opts, items))
+			message.ParseMode = "HTML"
+			message.DisableWebPagePreview = true
+			message.DisableNotification = inQuietHours(user, now)
+			message.ReplyMarkup = bestKeyboard(items)
+			bot.enqueue(message, "best:"+local.Format("2006-01-02"))
 		}
-	}
-}
 
-// contains проверяет, содержится ли элемент в очереди
-func (m *smartQueue) contains(s string) bool {
-	isThere := false
-	for _, q := range m.queue {
-		if q == s {
-			isThere = true
-			break
+		err = userdb.SetBestSentAt(strconv.FormatInt(user.ID, 10), now)
+		if err != nil {
+			logging.LogMinorError("mailoutBestArticles", "попытка сохранить время отправки лучших статей", err)
 		}
 	}
-	return isThere
 }
 
-// Хранят старые записи из RSS-ленты. Служат для получения всех новых статей (даже тех, которые были скрыты на время)
-// Задаются в функции bot.StartPooling(). Из-за этого make(map[string]gofeed.Item) не нужен.
-var oldArticles smartQueue
-
-// getAllArticles возвращает все gofeed.Item (в порядке убывания по времени, т.е новые раньше)
-func getAllArticles() ([]gofeed.Item, error) {
-	res := []gofeed.Item{}
-
-	// Получение ru RSS-ленты
-	if feedRu, err := getRSS(allRuHabrArticlesURL); err == nil {
-		for _, item := range feedRu.Items {
-			res = append(res, *item)
-		}
+// purgeInactiveUsers удаляет пользователей, которые неактивны дольше config.Get().PurgeAfter
+func purgeInactiveUsers() {
+	if config.Get().PurgeAfter == 0 {
+		return
 	}
 
-	// Получение en RSS-ленты
-	if feedEn, err := getRSS(allEnHabrArticlesURL); err == nil {
-		for _, item := range feedEn.Items {
-			res = append(res, *item)
-		}
+	n, err := userdb.PurgeInactiveUsers(config.Get().PurgeAfter)
+	if err != nil {
+		logging.LogMinorError("purgeInactiveUsers", "попытка удалить неактивных пользователей", err)
+		return
+	}
+	if n > 0 {
+		logging.LogInfo("Удалено неактивных пользователей: %d", n)
 	}
-
-	sort.Slice(res, func(i, j int) bool {
-		return !res[i].PublishedParsed.Before(*res[j].PublishedParsed)
-	})
-
-	return res, nil
 }
 
-// getNewArticles возвращает только новые статьи
-// Логика работы:
-// 1) Получаем все статьи из RSS-ленты
-// 2) Сравниваем их со статьями из предыдущего обновления (по URL). Если URL одинаковые, то удаляем статью
-// 3) Отправляем статьи в канал
-func getNewArticles(newArticlesChan chan<- article) {
-	ticker := time.NewTicker(time.Second * time.Duration(config.Data.Delay))
-	for ; true; <-ticker.C {
-		allItems, err := getAllArticles()
-		if err != nil {
-			logging.LogMinorError("getNewArticles", "Попытка получить статьи", err)
-			continue
-		}
-
-		// Отбираем только новые статьи
-		var newItems []gofeed.Item
-		for _, item := range allItems {
-			if !oldArticles.contains(item.Link) {
-				newItems = append(newItems, item)
-			}
-		}
-
-		// Проходим только по новым статьям в обратном порядке и отправляем их в канал
-		for i := len(newItems) - 1; i >= 0; i-- {
-			// Создание списка тегов статьи
-			var tags []string
-			for _, tag := range newItems[i].Categories {
-				// Форматирование от "Some Tag" к "some_tag"
-				tag = strings.Replace(tag, " ", "_", -1)
-				tag = strings.ToLower(tag)
-				tags = append(tags, tag)
+// mailout рассылает новые статьи, полученные из зарегистрированных источников.
+// После отмены ctx опрос источников останавливается, а уже полученные статьи рассылаются до завершения
+func (bot *Bot) mailout(ctx context.Context) {
+	// Регистрация пользовательских лент и старт опроса источников статей
+	syncUserFeeds()
+	sources.start(bot.articles)
+
+	for {
+		select {
+		case newArticle := <-bot.articles:
+			bot.distributeArticle(newArticle)
+		case <-ctx.Done():
+			sources.stop()
+			for {
+				select {
+				case newArticle := <-bot.articles:
+					bot.distributeArticle(newArticle)
+				default:
+					return
+				}
 			}
-
-			message := formatString(messageText,
-				map[string]string{
-					"title": newItems[i].Title,
-					"link":  newItems[i].Link})
-
-			article := article{title: newItems[i].Title, tags: tags, link: newItems[i].Link, message: message}
-
-			newArticlesChan <- article
-		}
-
-		// Обновляем список старых статей
-		for _, item := range allItems {
-			oldArticles.add(item.Link)
 		}
 	}
 }
 
-// mailoutBestArticles рассылает список лучших статей с Habrahabr
-func (bot *Bot) mailoutBestArticles() {
-	const limit = 7
+// distributeArticle отправляет статью всем пользователям, которым она подходит
+func (bot *Bot) distributeArticle(newArticle article) {
+	archiveArticle(newArticle)
 
-	users, err := userdb.GetAllUsers()
+	allUsers, err := userdb.GetActiveUsers()
 	if err != nil {
-		logging.LogMinorError("mailoutBestArticles", "попытка получить список пользователей", err)
+		logging.LogMinorError("distributeArticle", "ошибка при попытке получить список всех пользователей", err)
 		return
 	}
-	var habrBestArticles string
-
-	// Создание списка лучших статей с Habrahabr
-	feed, err := getRSS(bestRuHabrArticlesURL)
-	if err != nil {
-		logging.LogMinorError("mailoutBestArticles", "попытка получить RSS-ленту Habrahabr", err)
-	} else {
-		habrBestArticles = "<b>Лучшие статьи за этот день на Habrahabr:</b>\n"
 
-		// Создание списка статей (в виде строки)
-		for i, item := range feed.Items {
-			if i >= limit {
-				break
+	// Создание списка пользователей, которым нужно отправлять статьи
+	users := func() (users []userdb.User) {
+		for _, user := range allUsers {
+			if user.Mailout {
+				users = append(users, user)
 			}
-			number := strconv.Itoa(i + 1)
-			habrBestArticles += number + ") " + formatString("<a href='{link}'>{title}</a>", map[string]string{"link": item.Link, "title": item.Title}) + "\n"
 		}
-	}
-
-	if habrBestArticles == "" {
-		logging.LogMinorError("mailoutBestArticles", "списки лучших статей пусты ", errors.New(""))
-		return
-	}
+		return users
+	}()
 
-	// Проход по всем пользователям
 	var wg sync.WaitGroup
 	for _, user := range users {
 		wg.Add(1)
-
 		go func(user userdb.User) {
 			defer wg.Done()
 
-			if user.Mailout {
-				message := tgbotapi.NewMessage(user.ID, habrBestArticles)
-				message.ParseMode = "HTML"
-				message.DisableWebPagePreview = true
-				bot.messages <- message
+			if !isRecipient(user, newArticle) {
+				return
 			}
-		}(user)
-	}
-
-	wg.Wait()
-}
-
-// mailout рассылает статьи с периодичностью config.Delay наносекунд
-func (bot *Bot) mailout() {
-	go getNewArticles(bot.articles)
 
-	var (
-		allUsers []userdb.User
-		err      error
-	)
+			if user.Delivery != userdb.DeliveryInstant {
+				addToDigest(user, newArticle)
+				return
+			}
 
-	for newArticle := range bot.articles {
-		allUsers, err = userdb.GetAllUsers()
-		if err != nil {
-			logging.LogMinorError("mailout", "ошибка при попытке получить список всех пользователей", err)
-			return
-		}
+			message := tgbotapi.NewMessage(user.ID, newArticle.message)
+			message.ParseMode = "HTML"
+			message.ReplyMarkup = articleKeyboard(newArticle.link, newArticle.tags, user.Tags)
 
-		// Создание списка пользователей, которым нужно отправлять статьи
-		users := func() (users []userdb.User) {
-			for _, user := range allUsers {
-				if user.Mailout {
-					users = append(users, user)
+			if inQuietHours(user, time.Now()) {
+				if user.QuietMode == userdb.QuietHold {
+					holdArticle(user, newArticle)
+					return
 				}
+				message.DisableNotification = true
 			}
-			return users
-		}()
-
-		var wg sync.WaitGroup
-		for _, user := range users {
-			wg.Add(1)
-			go func(user userdb.User) {
-				defer wg.Done()
 
-				if shouldSend(user, newArticle) {
-					message := tgbotapi.NewMessage(user.ID, newArticle.message)
-					message.ParseMode = "HTML"
-					bot.messages <- message
-				}
-			}(user)
-		}
-		wg.Wait()
+			bot.enqueue(message, newArticle.link)
+		}(user)
+	}
+	wg.Wait()
+}
 
-		// Обновление ссылок в файле
-		allArticles := struct {
-			HabrArticles []string `json:"habr"`
-		}{
-			oldArticles.queue,
+// isRecipient проверяет, нужно ли отправлять статью пользователю
+// Статьи из пользовательских лент получают только подписчики ленты. Остальные статьи не отправляются, если
+// автор или компания заблокированы, и отправляются всегда, если пользователь на них подписан. Иначе – фильтруются по тегам
+func isRecipient(user userdb.User, newArticle article) bool {
+	if newArticle.feed != "" {
+		for _, feedURL := range user.Feeds {
+			if feedURL == newArticle.feed {
+				return true
+			}
 		}
+		return false
+	}
 
-		raw, _ := json.Marshal(allArticles)
-		err = ioutil.WriteFile("data/lastArticles.json", raw, 0644)
-		if err != nil {
-			logging.LogMinorError("Mailout", "попытка записать файл lastArticles.json", err)
-		}
+	if isBlocked(user, newArticle) {
+		return false
 	}
+	if isFollowed(user, newArticle) {
+		return true
+	}
+
+	return shouldSend(user, newArticle)
 }
 
-// habrMailout отвечает за рассылку статей с сайта Habrahabr.ru
+// shouldSend проверяет, подходит ли статья под теги, фильтры и ключевые слова пользователя
 func shouldSend(user userdb.User, newArticle article) bool {
-	if len(user.Tags) == 0 {
+	if len(user.Tags) == 0 && len(user.Filters) == 0 && len(user.Keywords) == 0 && len(user.Regexps) == 0 {
 		return true
 	}
 
-	// Проверка, есть ли теги пользователя в статье
+	articleTags := make(map[string]bool)
 	for _, tag := range newArticle.tags {
-		for _, userTag := range user.Tags {
-			if tag == userTag {
-				return true
-			}
+		articleTags[tag] = true
+	}
+
+	// Исключения в фильтрах проверяются раньше тегов (например, тег go и фильтр "go -вакансии")
+	for _, filter := range user.Filters {
+		if excludedByFilter(filter, articleTags) {
+			return false
+		}
+	}
+
+	// Проверка, есть ли теги пользователя в статье
+	for _, userTag := range user.Tags {
+		if articleTags[userTag] {
+			return true
+		}
+	}
+
+	// Проверка фильтров
+	for _, filter := range user.Filters {
+		if matchFilter(filter, articleTags) {
+			return true
 		}
 	}
 
-	return false
+	// Проверка ключевых слов и регулярных выражений
+	return matchKeywords(user, newArticle)
 }
@@ -0,0 +1,255 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/config"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/metrics"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+// Границы интервалов гистограмм (в секундах). Запрос getUpdates длится до updateTimeout секунд
+var (
+	fetchBuckets    = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	telegramBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 65}
+)
+
+// Метрики бота
+var (
+	feedFetchDuration = metrics.NewHistogram("habr_bot_feed_fetch_duration_seconds",
+		"Duration of fetching a source feed.", fetchBuckets, "source")
+	feedFetchFailures = metrics.NewCounter("habr_bot_feed_fetch_failures_total",
+		"Failed fetches of a source feed.", "source")
+	articlesDiscovered = metrics.NewCounter("habr_bot_articles_discovered_total",
+		"New articles found in a source feed.", "source")
+
+	messagesQueued = metrics.NewCounter("habr_bot_messages_queued_total",
+		"Messages received by the sender (direct – bot.messages, outbox – saved in the database).", "queue")
+	messagesSent = metrics.NewCounter("habr_bot_messages_sent_total",
+		"Messages sent successfully.")
+	messagesFailed = metrics.NewCounter("habr_bot_messages_failed_total",
+		"Failed attempts to send a message.", "reason")
+
+	telegramDuration = metrics.NewHistogram("habr_bot_telegram_request_duration_seconds",
+		"Duration of Telegram Bot API requests (until the response headers).", telegramBuckets, "method")
+	telegramErrors = metrics.NewCounter("habr_bot_telegram_request_errors_total",
+		"Telegram Bot API requests that failed or returned a non-2xx status.", "method")
+
+	commandsTotal = metrics.NewCounter("habr_bot_commands_total",
+		"Processed commands.", "command")
+)
+
+// Количество сообщений в очереди отправителя (см. sendWrapper)
+var sendQueueLength int64
+
+// Таймаут Long Pooling (в секундах)
+const updateTimeout = 60
+
+// Через сколько после последнего успешного запроса getUpdates получение обновлений считается неработающим
+const pollStaleAfter = 3 * updateTimeout * time.Second
+
+// Через сколько периодов опроса без успешного получения статей опрос источников считается неработающим
+const fetchStalePeriods = 3
+
+// Состояние бота для /healthz и /readyz
+var health struct {
+	sync.Mutex
+	// время последнего успешного запроса getUpdates (Long Pooling)
+	lastPoll time.Time
+	// получение обновлений запущено (webhook установлен или начат Long Pooling)
+	receiving bool
+	// время последнего успешного получения статей любого источника
+	lastFetch time.Time
+	// бот останавливается
+	stopping bool
+}
+
+// registerMetrics регистрирует метрики, значения которых вычисляются при запросе
+func (bot *Bot) registerMetrics() {
+	metrics.NewGaugeVecFunc("habr_bot_send_queue_length",
+		"Messages waiting to be sent (messages, outbox – channels, pending – queue of the sender).", "queue",
+		func() map[string]float64 {
+			return map[string]float64{
+				"messages": float64(len(bot.messages)),
+				"outbox":   float64(len(bot.outbox)),
+				"pending":  float64(atomic.LoadInt64(&sendQueueLength)),
+			}
+		})
+
+	metrics.NewGaugeVecFunc("habr_bot_users",
+		"Users by state (active – receive the mailout, stopped – stopped the mailout, blocked – blocked the bot).", "state",
+		func() map[string]float64 {
+			users, err := userdb.GetAllUsers()
+			if err != nil {
+				logging.LogMinorError("registerMetrics", "попытка получить список пользователей", err)
+				return nil
+			}
+
+			result := map[string]float64{"active": 0, "stopped": 0, "blocked": 0}
+			for _, user := range users {
+				switch {
+				case !user.Active:
+					result["blocked"]++
+				case user.Mailout:
+					result["active"]++
+				default:
+					result["stopped"]++
+				}
+			}
+			return result
+		})
+
+	metrics.NewCounterFunc("habr_bot_feed_gaps_total",
+		"Fetches of backfillable sources in which no already processed article was found.",
+		func() float64 { return float64(atomic.LoadUint64(&feedGaps)) })
+	metrics.NewCounterFunc("habr_bot_recovered_articles_total",
+		"Articles recovered from the listing pages after a gap.",
+		func() float64 { return float64(atomic.LoadUint64(&recoveredArticles)) })
+}
+
+// instrumentedTransport измеряет время запросов к Telegram Bot API
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Путь запроса – /bot<token>/<метод>
+	method := path.Base(req.URL.Path)
+
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	telegramDuration.Observe(time.Since(started).Seconds(), method)
+
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		telegramErrors.Inc(method)
+	} else if method == "getUpdates" {
+		health.Lock()
+		health.lastPoll = time.Now()
+		health.Unlock()
+	}
+	return resp, err
+}
+
+// receivingStarted отмечает, что получение обновлений запущено
+func receivingStarted() {
+	health.Lock()
+	health.receiving = true
+	health.Unlock()
+}
+
+// fetchSucceeded отмечает успешное получение статей источника
+func fetchSucceeded() {
+	health.Lock()
+	health.lastFetch = time.Now()
+	health.Unlock()
+}
+
+// checkHealth возвращает результаты проверок: работает ли получение обновлений и опрос источников
+func checkHealth() (ok bool, checks []string) {
+	health.Lock()
+	defer health.Unlock()
+
+	ok = true
+	now := time.Now()
+
+	switch {
+	case config.Get().WebhookURL != "":
+		if health.receiving {
+			checks = append(checks, "updates: ok (webhook)")
+		} else {
+			ok = false
+			checks = append(checks, "updates: webhook isn't set")
+		}
+	case health.lastPoll.IsZero() && now.Sub(startedAt) < pollStaleAfter:
+		checks = append(checks, "updates: starting")
+	case now.Sub(health.lastPoll) < pollStaleAfter:
+		checks = append(checks, "updates: ok")
+	default:
+		ok = false
+		checks = append(checks, "updates: no successful getUpdates since "+formatHealthTime(health.lastPoll))
+	}
+
+	fetchStaleAfter := fetchStalePeriods * config.Get().Delay
+	switch {
+	case health.lastFetch.IsZero() && now.Sub(startedAt) < fetchStaleAfter:
+		checks = append(checks, "feeds: starting")
+	case now.Sub(health.lastFetch) < fetchStaleAfter:
+		checks = append(checks, "feeds: ok")
+	default:
+		ok = false
+		checks = append(checks, "feeds: no successful fetch since "+formatHealthTime(health.lastFetch))
+	}
+
+	return ok, checks
+}
+
+// isReady возвращает true, если бот начал получать обновления и не останавливается
+func isReady() bool {
+	health.Lock()
+	defer health.Unlock()
+
+	return health.receiving && !health.stopping
+}
+
+func formatHealthTime(t time.Time) string {
+	if t.IsZero() {
+		return "start"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// listenMetrics запускает HTTP-сервер с метриками (/metrics) и проверками состояния (/healthz, /readyz).
+// Сервер останавливается функцией stop
+func (bot *Bot) listenMetrics() (stop func()) {
+	addr := config.Get().MetricsListen
+	if addr == "" {
+		return func() {}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		ok, checks := checkHealth()
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte(strings.Join(checks, "\n") + "\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !isReady() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			// Бот может работать и без метрик
+			logging.LogMinorError("listenMetrics", "попытка запустить HTTP-сервер метрик", err)
+		}
+	}()
+	logging.LogInfo("Метрики: %s/metrics", addr)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logging.LogMinorError("listenMetrics", "попытка остановить HTTP-сервер метрик", err)
+		}
+	}
+}
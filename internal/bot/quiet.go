@@ -0,0 +1,269 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+// Названия режимов тихих часов для пользователя
+var quietModeNames = map[string]string{
+	userdb.QuietHold:   "статьи откладываются до окончания тихих часов",
+	userdb.QuietSilent: "статьи присылаются без звука",
+}
+
+// Кэш часовых поясов
+var locations = struct {
+	sync.Mutex
+	m map[string]*time.Location
+}{m: make(map[string]*time.Location)}
+
+// loadLocation возвращает часовой пояс по названию из базы IANA
+func loadLocation(name string) (*time.Location, error) {
+	locations.Lock()
+	defer locations.Unlock()
+
+	if loc, ok := locations.m[name]; ok {
+		return loc, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.m[name] = loc
+	return loc, nil
+}
+
+// userLocation возвращает часовой пояс пользователя. Если он не задан, используется часовой пояс сервера
+func userLocation(user userdb.User) *time.Location {
+	if user.Timezone == "" {
+		return time.Local
+	}
+
+	loc, err := loadLocation(user.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// parseClock разбирает время вида "23:00". Возвращает количество минут с начала суток
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.New("неверный формат времени «" + s + "» (пример: 23:00)")
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseQuietHours разбирает тихие часы вида "23:00-08:00". Возвращает начало и конец в минутах с начала суток
+func parseQuietHours(s string) (start, end int, err error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("неверный формат тихих часов (пример: 23:00-08:00)")
+	}
+
+	start, err = parseClock(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err = parseClock(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, errors.New("начало и конец тихих часов не могут совпадать")
+	}
+	return start, end, nil
+}
+
+// inQuietHours проверяет, действуют ли сейчас тихие часы пользователя
+func inQuietHours(user userdb.User, now time.Time) bool {
+	if user.Quiet == "" {
+		return false
+	}
+
+	start, end, err := parseQuietHours(user.Quiet)
+	if err != nil {
+		return false
+	}
+
+	local := now.In(userLocation(user))
+	minutes := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minutes >= start && minutes < end
+	}
+	// Тихие часы переходят через полночь
+	return minutes >= start || minutes < end
+}
+
+// holdArticle откладывает статью до окончания тихих часов
+func holdArticle(user userdb.User, a article) {
+	err := userdb.AddHeldArticle(strconv.FormatInt(user.ID, 10), userdb.PendingArticle{Title: a.title, Link: a.link})
+	if err != nil {
+		logging.LogMinorError("holdArticle", "попытка отложить статью для пользователя "+strconv.FormatInt(user.ID, 10), err)
+	}
+}
+
+// releaseHeldArticles отправляет статьи, отложенные на время тихих часов, пользователям, у которых тихие часы закончились
+func (bot *Bot) releaseHeldArticles() {
+	ids, err := userdb.GetHeldArticlesOwners()
+	if err != nil {
+		logging.LogMinorError("releaseHeldArticles", "попытка получить список пользователей с отложенными статьями", err)
+		return
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		user, err := userdb.GetUser(id)
+		if err != nil {
+			logging.LogMinorError("releaseHeldArticles", "попытка получить данные пользователя "+id, err)
+			continue
+		}
+		if inQuietHours(user, now) {
+			continue
+		}
+
+		articles, err := userdb.TakeHeldArticles(id)
+		if err != nil {
+			logging.LogMinorError("releaseHeldArticles", "попытка получить отложенные статьи пользователя "+id, err)
+			continue
+		}
+		if !user.Mailout || !user.Active {
+			continue
+		}
+
+		for _, a := range articles {
+			text := formatString(messageText, map[string]string{"title": html.EscapeString(a.Title), "link": a.Link})
+			message := tgbotapi.NewMessage(user.ID, text)
+			message.ParseMode = "HTML"
+			message.ReplyMarkup = archivedKeyboard(a.Link, user.Tags)
+			bot.enqueue(message, a.Link)
+		}
+	}
+}
+
+// setTimezone устанавливает часовой пояс пользователя (пример: /timezone Europe/Moscow)
+// Без аргументов показывает текущий часовой пояс
+func (bot *Bot) setTimezone(msg *tgbotapi.Message) {
+	timezone := strings.TrimSpace(msg.CommandArguments())
+	id := strconv.FormatInt(msg.Chat.ID, 10)
+
+	if timezone == "" {
+		user, err := userdb.GetUser(id)
+		if err != nil {
+			data := logging.ErrorData{
+				Error:    err,
+				Username: msg.Chat.UserName,
+				UserID:   msg.Chat.ID,
+				Command:  "/...timezone",
+				AddInfo:  "попытка получить данные пользователя"}
+			bot.logErrorAndNotify(data)
+			return
+		}
+
+		loc := userLocation(user)
+		text := fmt.Sprintf("Часовой пояс: %s (сейчас %s)\n\nДля изменения: /timezone Europe/Moscow",
+			loc.String(), time.Now().In(loc).Format("15:04"))
+		bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, text)
+		return
+	}
+
+	loc, err := loadLocation(timezone)
+	// Пустое название и "Local" соответствуют часовому поясу сервера, а не пользователя
+	if err != nil || timezone == "Local" {
+		bot.sendErrorToUser("неизвестный часовой пояс. Нужно указать название из базы IANA (пример: /timezone Europe/Moscow)", msg.Chat.ID)
+		return
+	}
+
+	err = userdb.SetTimezone(id, loc.String())
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...timezone",
+			AddInfo:  "попытка изменить часовой пояс"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	text := fmt.Sprintf("Часовой пояс изменён на %s (сейчас %s)", loc.String(), time.Now().In(loc).Format("15:04"))
+	bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, text)
+}
+
+// setQuietHours устанавливает тихие часы (пример: /quiet 23:00-08:00 silent, /quiet off)
+// Без аргументов показывает текущие тихие часы
+func (bot *Bot) setQuietHours(msg *tgbotapi.Message) {
+	args := strings.Fields(strings.ToLower(msg.CommandArguments()))
+	id := strconv.FormatInt(msg.Chat.ID, 10)
+
+	if len(args) == 0 {
+		user, err := userdb.GetUser(id)
+		if err != nil {
+			data := logging.ErrorData{
+				Error:    err,
+				Username: msg.Chat.UserName,
+				UserID:   msg.Chat.ID,
+				Command:  "/...quiet",
+				AddInfo:  "попытка получить данные пользователя"}
+			bot.logErrorAndNotify(data)
+			return
+		}
+
+		text := "🌙 Тихие часы выключены"
+		if user.Quiet != "" {
+			text = "🌙 Тихие часы: " + user.Quiet + " (" + userLocation(user).String() + ")\nРежим: " + quietModeNames[user.QuietMode]
+		}
+		text += "\n\nДля изменения: /quiet 23:00-08:00 hold (отложить статьи) или /quiet 23:00-08:00 silent (присылать без звука). Выключить: /quiet off"
+		bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, text)
+		return
+	}
+
+	quiet := ""
+	mode := userdb.QuietHold
+	if args[0] != "off" {
+		start, end, err := parseQuietHours(args[0])
+		if err != nil {
+			bot.sendErrorToUser(err.Error(), msg.Chat.ID)
+			return
+		}
+		quiet = fmt.Sprintf("%02d:%02d-%02d:%02d", start/60, start%60, end/60, end%60)
+
+		if len(args) > 1 {
+			mode = args[1]
+			if _, ok := quietModeNames[mode]; !ok {
+				bot.sendErrorToUser("неизвестный режим. Доступные режимы: hold, silent", msg.Chat.ID)
+				return
+			}
+		}
+	}
+
+	err := userdb.SetQuietHours(id, quiet, mode)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...quiet",
+			AddInfo:  "попытка изменить тихие часы"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	text := "🌙 Тихие часы выключены"
+	if quiet != "" {
+		text = "🌙 Тихие часы: " + quiet + "\nРежим: " + quietModeNames[mode]
+	}
+	bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, text)
+}
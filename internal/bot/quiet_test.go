@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		s          string
+		start, end int
+	}{
+		{"23:00-08:00", 23 * 60, 8 * 60},
+		{"13:30-14:45", 13*60 + 30, 14*60 + 45},
+		{" 00:00 - 7:05 ", 0, 7*60 + 5},
+	}
+	for _, tt := range tests {
+		start, end, err := parseQuietHours(tt.s)
+		if err != nil {
+			t.Errorf("parseQuietHours(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+		if start != tt.start || end != tt.end {
+			t.Errorf("parseQuietHours(%q) = %d, %d, want %d, %d", tt.s, start, end, tt.start, tt.end)
+		}
+	}
+
+	for _, s := range []string{"", "23:00", "23:00-08:00-09:00", "25:00-08:00", "23:60-08:00", "ночь-утро", "08:00-08:00"} {
+		if _, _, err := parseQuietHours(s); err == nil {
+			t.Errorf("parseQuietHours(%q): expected an error", s)
+		}
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2019, 5, 20, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		quiet  string
+		now    time.Time
+		active bool
+	}{
+		// В пределах суток
+		{"13:00-14:00", at(12, 59), false},
+		{"13:00-14:00", at(13, 0), true},
+		{"13:00-14:00", at(13, 59), true},
+		{"13:00-14:00", at(14, 0), false},
+		// Через полночь
+		{"23:00-08:00", at(22, 59), false},
+		{"23:00-08:00", at(23, 0), true},
+		{"23:00-08:00", at(0, 0), true},
+		{"23:00-08:00", at(3, 30), true},
+		{"23:00-08:00", at(7, 59), true},
+		{"23:00-08:00", at(8, 0), false},
+		{"23:00-08:00", at(12, 0), false},
+		// Тихие часы не заданы или некорректны
+		{"", at(3, 0), false},
+		{"08:00", at(3, 0), false},
+	}
+
+	for _, tt := range tests {
+		user := userdb.User{Quiet: tt.quiet, Timezone: "UTC"}
+		if got := inQuietHours(user, tt.now); got != tt.active {
+			t.Errorf("inQuietHours(%q, %s) = %v, want %v", tt.quiet, tt.now.Format("15:04"), got, tt.active)
+		}
+	}
+}
+
+func TestInQuietHoursTimezone(t *testing.T) {
+	if _, err := loadLocation("Asia/Vladivostok"); err != nil {
+		t.Skip("tzdata isn't available: ", err)
+	}
+
+	// 14:00 UTC – полночь во Владивостоке (UTC+10)
+	now := time.Date(2019, 5, 20, 14, 0, 0, 0, time.UTC)
+	if !inQuietHours(userdb.User{Quiet: "23:00-08:00", Timezone: "Asia/Vladivostok"}, now) {
+		t.Error("quiet hours must be checked in the user's timezone")
+	}
+	if inQuietHours(userdb.User{Quiet: "23:00-08:00", Timezone: "UTC"}, now) {
+		t.Error("quiet hours must not be active at 14:00 UTC")
+	}
+}
@@ -0,0 +1,84 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/config"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+// Одновременно может выполняться только одна перезагрузка (SIGHUP и /reload)
+var reloadMu sync.Mutex
+
+// Reload перечитывает конфигурацию и ids.json и применяет их без перезапуска бота.
+// Если конфигурация или список id некорректны, не применяется ничего. Возвращает список изменений
+func (bot *Bot) Reload() ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	newConfig, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	// Папка с данными применяется только при запуске, поэтому список id читается из текущей папки
+	ids, err := readCorrectIDs(config.DataPath("ids.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("ids.json: %s", err)
+	}
+
+	old := config.Get()
+	changes := config.Apply(newConfig)
+	added, err := userdb.AllowUsers(formatIDs(ids))
+	if err != nil {
+		logging.LogMinorError("Reload", "попытка разрешить доступ id из ids.json", err)
+	}
+	if len(added) > 0 {
+		changes = append(changes, fmt.Sprintf("ids: доступ разрешён %v", added))
+	}
+
+	current := config.Get()
+	if current.Rate != old.Rate {
+		// В канале хранится только последнее значение
+		select {
+		case <-bot.rates:
+		default:
+		}
+		bot.rates <- current.Rate
+	}
+	if current.Delay != old.Delay {
+		sources.setInterval(current.Delay)
+	}
+
+	if len(changes) == 0 {
+		logging.LogInfo("Конфигурация перезагружена, изменений нет")
+	} else {
+		logging.LogInfo("Конфигурация перезагружена:\n%s", strings.Join(changes, "\n"))
+	}
+	return changes, nil
+}
+
+// reloadCommand перезагружает конфигурацию по команде администратора (/reload)
+func (bot *Bot) reloadCommand(msg *tgbotapi.Message) {
+	if !bot.checkAdmin(msg) {
+		return
+	}
+
+	changes, err := bot.Reload()
+	if err != nil {
+		logging.LogMinorError("reloadCommand", "попытка перезагрузить конфигурацию", err)
+		bot.sendErrorToUser("конфигурация не перезагружена: "+err.Error(), msg.Chat.ID)
+		return
+	}
+
+	text := "Конфигурация перезагружена, изменений нет"
+	if len(changes) > 0 {
+		text = "Конфигурация перезагружена:\n" + strings.Join(changes, "\n")
+	}
+	bot.messages <- tgbotapi.NewMessage(msg.Chat.ID, text)
+}
@@ -0,0 +1,261 @@
+package bot
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/config"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+const (
+	// Количество результатов поиска на одной странице
+	searchPerPage = 5
+	// Количество лучших по релевантности статей, которые ранжируются с учётом даты публикации
+	maxSearchCandidates = 200
+	// Максимальная длина поискового запроса
+	maxSearchQueryLength = 100
+	// Минимальная длина слова для индексации
+	minTermLength = 2
+	// Через сколько дней релевантность статьи уменьшается в 2 раза
+	searchHalfLife = 30
+
+	// Префикс первой строки сообщения с результатами поиска. После него идёт запрос
+	searchHeader = "🔎 Поиск: "
+)
+
+// Веса слов в зависимости от того, где они встретились
+const (
+	titleWeight       = 3
+	tagWeight         = 2
+	authorWeight      = 2
+	descriptionWeight = 1
+)
+
+// toTerms возвращает основы слов текста (без повторов)
+func toTerms(text string) []string {
+	var terms []string
+	for _, word := range splitWords(text) {
+		if utf8.RuneCountInString(word) < minTermLength {
+			continue
+		}
+		terms = append(terms, stem(word))
+	}
+	return toSet(terms)
+}
+
+// indexTerms возвращает слова статьи для индекса и их веса.
+// Вес слова – сумма весов частей статьи, в которых оно встретилось
+func indexTerms(a article) map[string]int {
+	terms := make(map[string]int)
+	add := func(text string, weight int) {
+		for _, term := range toTerms(text) {
+			terms[term] += weight
+		}
+	}
+
+	add(a.title, titleWeight)
+	add(strings.Replace(strings.Join(a.tags, " "), "_", " ", -1), tagWeight)
+	add(a.author, authorWeight)
+	add(a.description, descriptionWeight)
+
+	return terms
+}
+
+// archiveArticle сохраняет статью в архив для поиска и восстановления тегов по номеру поста.
+// Статьи из пользовательских лент не сохраняются: их не должны видеть другие пользователи
+func archiveArticle(a article) {
+	if a.feed != "" {
+		return
+	}
+
+	archived := userdb.ArchivedArticle{
+		PostID:      articleKey(a.link),
+		Title:       a.title,
+		Link:        a.link,
+		Tags:        a.tags,
+		Author:      a.author,
+		Description: a.description,
+		Published:   a.published,
+		AddedAt:     time.Now(),
+		Terms:       indexTerms(a),
+	}
+	err := userdb.PutArchived(archived)
+	if err != nil {
+		logging.LogMinorError("archiveArticle", "попытка сохранить статью "+archived.PostID, err)
+	}
+}
+
+// cleanArchive удаляет из архива статьи старше -archive-ttl дней
+func cleanArchive() {
+	period := config.Get().ArchiveTTL
+	_, err := userdb.CleanArchive(period)
+	if err != nil {
+		logging.LogMinorError("cleanArchive", "попытка удалить старые статьи из архива", err)
+	}
+}
+
+// searchArchive ищет статьи по запросу. Статьи, содержащие больше слов запроса, идут раньше,
+// при одинаковом количестве слов – по релевантности с учётом даты публикации
+func searchArchive(query string) ([]userdb.ArchivedArticle, error) {
+	results, err := userdb.SearchArchive(toTerms(query))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Matched != results[j].Matched {
+			return results[i].Matched > results[j].Matched
+		}
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > maxSearchCandidates {
+		results = results[:maxSearchCandidates]
+	}
+
+	type ranked struct {
+		article userdb.ArchivedArticle
+		matched int
+		rank    float64
+	}
+
+	now := time.Now()
+	list := make([]ranked, 0, len(results))
+	for _, r := range results {
+		a, ok, err := userdb.GetArchived(r.PostID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		published := a.Published
+		if published.IsZero() {
+			published = a.AddedAt
+		}
+		age := now.Sub(published).Hours() / 24
+		list = append(list, ranked{article: a, matched: r.Matched, rank: float64(r.Score) * math.Pow(0.5, age/searchHalfLife)})
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].matched != list[j].matched {
+			return list[i].matched > list[j].matched
+		}
+		return list[i].rank > list[j].rank
+	})
+
+	articles := make([]userdb.ArchivedArticle, 0, len(list))
+	for _, r := range list {
+		articles = append(articles, r.article)
+	}
+	return articles, nil
+}
+
+// searchPage возвращает текст страницы page (с 0) результатов поиска и кнопки для перехода между страницами
+func searchPage(query string, articles []userdb.ArchivedArticle, page int) (string, *tgbotapi.InlineKeyboardMarkup) {
+	text := searchHeader + html.EscapeString(query) + "\n"
+	if len(articles) == 0 {
+		return text + "Ничего не найдено", nil
+	}
+
+	pages := (len(articles) + searchPerPage - 1) / searchPerPage
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	text += "Найдено статей: " + strconv.Itoa(len(articles)) + "\n\n"
+	for i := page * searchPerPage; i < len(articles) && i < (page+1)*searchPerPage; i++ {
+		a := articles[i]
+		text += strconv.Itoa(i+1) + ") " + formatString("<a href='{link}'>{title}</a>",
+			map[string]string{"link": a.Link, "title": html.EscapeString(a.Title)})
+		if !a.Published.IsZero() {
+			text += " (" + a.Published.Format("02.01.2006") + ")"
+		}
+		text += "\n"
+	}
+
+	if pages == 1 {
+		return text, nil
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("⬅️", callbackSearch+strconv.Itoa(page-1)))
+	}
+	row = append(row, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(page+1)+"/"+strconv.Itoa(pages), callbackSearch+strconv.Itoa(page)))
+	if page < pages-1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("➡️", callbackSearch+strconv.Itoa(page+1)))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(row)
+
+	return text, &markup
+}
+
+// search ищет статьи в архиве (пример: /search горутины)
+func (bot *Bot) search(msg *tgbotapi.Message) {
+	query := strings.Join(strings.Fields(msg.CommandArguments()), " ")
+	if query == "" {
+		bot.sendErrorToUser("запрос не может быть пустым (пример: /search горутины)", msg.Chat.ID)
+		return
+	}
+	if utf8.RuneCountInString(query) > maxSearchQueryLength {
+		bot.sendErrorToUser("запрос не может быть длиннее "+strconv.Itoa(maxSearchQueryLength)+" символов", msg.Chat.ID)
+		return
+	}
+
+	articles, err := searchArchive(query)
+	if err != nil {
+		data := logging.ErrorData{
+			Error:    err,
+			Username: msg.Chat.UserName,
+			UserID:   msg.Chat.ID,
+			Command:  "/...search",
+			AddInfo:  "попытка найти статьи"}
+		bot.logErrorAndNotify(data)
+		return
+	}
+
+	text, markup := searchPage(query, articles, 0)
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	message.ParseMode = "HTML"
+	message.DisableWebPagePreview = true
+	if markup != nil {
+		message.ReplyMarkup = markup
+	}
+	bot.messages <- message
+}
+
+// searchFromCallback показывает другую страницу результатов поиска в том же сообщении.
+// Запрос берётся из первой строки сообщения, поэтому данные кнопки содержат только номер страницы
+func (bot *Bot) searchFromCallback(query *tgbotapi.CallbackQuery, pageData string) {
+	page, err := strconv.Atoi(pageData)
+	firstLine := strings.SplitN(query.Message.Text, "\n", 2)[0]
+	if err != nil || !strings.HasPrefix(firstLine, searchHeader) {
+		bot.answerCallback(query, "Неверная страница")
+		return
+	}
+	searchQuery := strings.TrimPrefix(firstLine, searchHeader)
+
+	articles, err := searchArchive(searchQuery)
+	if err != nil {
+		logging.LogMinorError("searchFromCallback", "попытка найти статьи по запросу "+searchQuery, err)
+		bot.answerCallback(query, "Что-то пошло не так")
+		return
+	}
+
+	text, markup := searchPage(searchQuery, articles, page)
+	bot.editMessage(query.Message.Chat.ID, query.Message.MessageID, text, markup)
+	bot.answerCallback(query, "")
+}
@@ -0,0 +1,336 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+const (
+	// Минимальный интервал между сообщениями в один чат (ограничение Telegram – 1 сообщение в секунду)
+	chatSendInterval = time.Second
+	// Максимальное количество попыток отправить сообщение при сетевых ошибках
+	maxSendAttempts = 5
+	// Задержка перед первой повторной попыткой. Каждая следующая задержка в 2 раза больше
+	baseRetryDelay = time.Second
+)
+
+// Ошибки Telegram, после которых пользователю больше нельзя отправлять сообщения, и соответствующие причины деактивации
+var deactivationErrors = map[string]string{
+	"Forbidden: bot was blocked by the user": "blocked",
+	"Forbidden: user is deactivated":         "deleted",
+	"Bad Request: chat not found":            "chat_not_found",
+}
+
+// Сколько хранятся ключи защиты от повторной отправки
+const outboxKeysTTL = 30 * 24 * time.Hour
+
+// Сколько времени при остановке бота отправляются оставшиеся сообщения.
+// Не отправленные за это время сообщения сохраняются в базе данных и отправляются после перезапуска
+const drainTimeout = 10 * time.Second
+
+// outgoing – сообщение в очереди на отправку
+type outgoing struct {
+	msg tgbotapi.MessageConfig
+	// номер сообщения в очереди в базе данных. 0, если сообщение не сохранено
+	outboxID uint64
+	// номер попытки отправки (с 0)
+	attempt int
+	// сообщение нельзя отправлять раньше этого времени
+	notBefore time.Time
+}
+
+// sendResult – результат отправки сообщения
+type sendResult struct {
+	out outgoing
+	// время, на которое Telegram просит приостановить отправку (ответ 429 Too Many Requests)
+	retryAfter time.Duration
+	// сообщение нужно отправить повторно (временная ошибка)
+	retry bool
+	// сообщение не удалось отправить из-за временных ошибок, попытки закончились
+	undelivered bool
+}
+
+// send отправляет сообщение и классифицирует ошибку
+func (bot *Bot) send(out outgoing) sendResult {
+	msg := out.msg
+	// Сообщения из очереди в базе данных, поставленные до запрета доступа, не отправляются
+	if out.outboxID != 0 && isDenied(msg.ChatID) {
+		messagesFailed.Inc("denied")
+		return sendResult{out: out}
+	}
+
+	_, err := bot.botAPI.Send(msg)
+	if err == nil {
+		countSent()
+		messagesSent.Inc()
+		return sendResult{out: out}
+	}
+
+	if apiErr, ok := err.(tgbotapi.Error); ok {
+		if apiErr.RetryAfter > 0 {
+			messagesFailed.Inc("too_many_requests")
+			return sendResult{out: out, retryAfter: time.Duration(apiErr.RetryAfter) * time.Second, retry: true}
+		}
+
+		if reason, ok := deactivationErrors[apiErr.Message]; ok {
+			messagesFailed.Inc(reason)
+			deactivateUser(msg.ChatID, reason)
+			return sendResult{out: out}
+		}
+
+		messagesFailed.Inc("api_error")
+		text := fmt.Sprintf("UserID: %d", msg.ChatID)
+		logging.LogMinorError("send", text, err)
+		return sendResult{out: out}
+	}
+
+	// Ошибка не от Telegram API (сеть, таймаут и т.д.) – пробуем ещё раз
+	messagesFailed.Inc("network")
+	text := fmt.Sprintf("UserID: %d Attempt: %d", msg.ChatID, out.attempt+1)
+	logging.LogMinorError("send", text, err)
+	if out.attempt+1 >= maxSendAttempts {
+		return sendResult{out: out, undelivered: true}
+	}
+	return sendResult{out: out, retry: true}
+}
+
+// enqueue сохраняет сообщение в базе данных и ставит его в очередь на отправку.
+// Сообщение будет отправлено, даже если бот перезапустится до отправки (at-least-once).
+// Если key не пустой, то сообщение с таким же ключом отправляется пользователю только один раз
+func (bot *Bot) enqueue(msg tgbotapi.MessageConfig, key string) {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		logging.LogMinorError("enqueue", fmt.Sprintf("UserID: %d", msg.ChatID), err)
+		bot.messages <- msg
+		return
+	}
+
+	id, ok, err := userdb.EnqueueOutbox(msg.ChatID, key, raw)
+	if err != nil {
+		// Сообщение всё равно отправляется, но без сохранения
+		logging.LogMinorError("enqueue", fmt.Sprintf("UserID: %d", msg.ChatID), err)
+		bot.messages <- msg
+		return
+	}
+	if !ok {
+		// Сообщение уже было поставлено в очередь
+		return
+	}
+
+	bot.outbox <- outgoing{msg: msg, outboxID: id}
+}
+
+// resumeOutbox ставит в очередь сообщения, которые не были отправлены до перезапуска. Список items нужно получить
+// до запуска рассылки: сообщения, сохранённые после этого, уже поставлены в очередь функцией enqueue
+func (bot *Bot) resumeOutbox(items []userdb.OutboxItem) {
+	if len(items) > 0 {
+		logging.LogInfo("Неотправленных сообщений: %d", len(items))
+	}
+
+	for _, item := range items {
+		var msg tgbotapi.MessageConfig
+		if err := json.Unmarshal(item.Message, &msg); err != nil {
+			logging.LogMinorError("resumeOutbox", fmt.Sprintf("UserID: %d", item.ChatID), err)
+			userdb.CompleteOutbox(item.ID)
+			continue
+		}
+		bot.outbox <- outgoing{msg: msg, outboxID: item.ID}
+	}
+}
+
+// completeOutbox удаляет отправленное сообщение из очереди в базе данных
+func completeOutbox(res sendResult) {
+	if res.out.outboxID == 0 || res.retry {
+		return
+	}
+	// Если попытки закончились, сообщение остаётся в базе данных и будет отправлено после перезапуска
+	if res.undelivered {
+		return
+	}
+
+	err := userdb.CompleteOutbox(res.out.outboxID)
+	if err != nil {
+		logging.LogMinorError("completeOutbox", fmt.Sprintf("UserID: %d", res.out.msg.ChatID), err)
+	}
+}
+
+// cleanOutboxKeys удаляет устаревшие ключи защиты от повторной отправки
+func cleanOutboxKeys() {
+	_, err := userdb.CleanOutboxKeys(outboxKeysTTL)
+	if err != nil {
+		logging.LogMinorError("cleanOutboxKeys", "попытка удалить старые ключи", err)
+	}
+}
+
+// deactivateUser помечает пользователя неактивным, чтобы ему больше не отправлялись статьи
+func deactivateUser(chatID int64, reason string) {
+	err := userdb.DeactivateUser(strconv.FormatInt(chatID, 10), reason)
+	if err != nil {
+		logging.LogMinorError("deactivateUser", fmt.Sprintf("UserID: %d Reason: %s", chatID, reason), err)
+		return
+	}
+	logging.LogInfo("Пользователь деактивирован: UserID: %d Reason: %s", chatID, reason)
+}
+
+// persistQueue сохраняет в базе данных сообщения, которые не успели отправиться до остановки бота
+func persistQueue(queue []outgoing) {
+	var n int
+	for _, out := range queue {
+		if out.outboxID != 0 {
+			// Сообщение уже сохранено
+			continue
+		}
+
+		raw, err := json.Marshal(out.msg)
+		if err == nil {
+			_, _, err = userdb.EnqueueOutbox(out.msg.ChatID, "", raw)
+		}
+		if err != nil {
+			logging.LogMinorError("persistQueue", fmt.Sprintf("UserID: %d", out.msg.ChatID), err)
+			continue
+		}
+		n++
+	}
+	if n > 0 {
+		logging.LogInfo("Сохранено неотправленных сообщений: %d", n)
+	}
+}
+
+// sendWrapper – обёртка над bot.send()
+// Отправляет сообщения не чаще, чем раз в rate (глобальное ограничение Telegram – ~30 сообщений в секунду),
+// и не чаще, чем раз в chatSendInterval в один чат. При ответе 429 сообщение возвращается в очередь
+// и чат приостанавливается на retry_after секунд, при сетевых ошибках – повторяется с увеличивающейся задержкой
+//
+// После отмены ctx отправляются только сообщения, не сохранённые в базе данных (сохранённые будут отправлены
+// после перезапуска). Функция завершается, когда такие сообщения закончатся или пройдёт drainTimeout
+func (bot *Bot) sendWrapper(ctx context.Context, rate time.Duration) {
+	limiter := time.NewTicker(rate)
+	// limiter заменяется при изменении rate, поэтому останавливается последний
+	defer func() { limiter.Stop() }()
+
+	var (
+		queue []outgoing
+		// время последней отправки (или время, до которого отправка приостановлена) для каждого чата
+		chatReadyAt = make(map[int64]time.Time)
+		results     = make(chan sendResult, 100)
+		// количество отправляемых в данный момент сообщений
+		inFlight int
+
+		stop     = ctx.Done()
+		draining bool
+		deadline <-chan time.Time
+	)
+
+	// unsaved оставляет в очереди только сообщения, не сохранённые в базе данных
+	unsaved := func(queue []outgoing) []outgoing {
+		var result []outgoing
+		for _, out := range queue {
+			if out.outboxID == 0 {
+				result = append(result, out)
+			}
+		}
+		return result
+	}
+
+	for {
+		atomic.StoreInt64(&sendQueueLength, int64(len(queue)))
+
+		if draining && len(queue) == 0 && inFlight == 0 && len(bot.messages) == 0 {
+			logging.LogInfo("Очередь сообщений пуста")
+			return
+		}
+
+		select {
+		case <-stop:
+			stop = nil
+			draining = true
+			deadline = time.After(drainTimeout)
+			queue = unsaved(queue)
+
+		case newRate := <-bot.rates:
+			limiter.Stop()
+			limiter = time.NewTicker(newRate)
+
+		case <-deadline:
+			for len(bot.messages) > 0 {
+				queue = append(queue, outgoing{msg: <-bot.messages})
+			}
+			persistQueue(queue)
+			return
+
+		case msg, ok := <-bot.messages:
+			if !ok {
+				return
+			}
+			messagesQueued.Inc("direct")
+			queue = append(queue, outgoing{msg: msg})
+
+		case out := <-bot.outbox:
+			if !draining {
+				messagesQueued.Inc("outbox")
+				queue = append(queue, out)
+			}
+
+		case res := <-results:
+			inFlight--
+			if !res.retry || (draining && res.out.outboxID != 0) {
+				continue
+			}
+
+			out := res.out
+			out.attempt++
+			if res.retryAfter > 0 {
+				chatReadyAt[out.msg.ChatID] = time.Now().Add(res.retryAfter)
+				logging.LogInfo("Too Many Requests: UserID: %d RetryAfter: %s", out.msg.ChatID, res.retryAfter)
+			} else {
+				out.notBefore = time.Now().Add(baseRetryDelay << uint(out.attempt-1))
+			}
+			// Сообщение возвращается в начало очереди, чтобы сохранить порядок сообщений в чате
+			queue = append([]outgoing{out}, queue...)
+
+		case now := <-limiter.C:
+			// чаты, в которые сейчас нельзя отправлять сообщения (чтобы не нарушить порядок сообщений)
+			var blocked map[int64]bool
+			for i, out := range queue {
+				if blocked[out.msg.ChatID] {
+					continue
+				}
+				if now.Before(out.notBefore) || now.Before(chatReadyAt[out.msg.ChatID]) {
+					if blocked == nil {
+						blocked = make(map[int64]bool)
+					}
+					blocked[out.msg.ChatID] = true
+					continue
+				}
+
+				queue = append(queue[:i], queue[i+1:]...)
+				chatReadyAt[out.msg.ChatID] = now.Add(chatSendInterval)
+				inFlight++
+				go func(out outgoing) {
+					res := bot.send(out)
+					completeOutbox(res)
+					results <- res
+				}(out)
+				break
+			}
+
+			// Удаление устаревших записей
+			if len(queue) == 0 && len(chatReadyAt) > 0 {
+				for chatID, t := range chatReadyAt {
+					if now.After(t) {
+						delete(chatReadyAt, chatID)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/config"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/userdb"
+)
+
+// Source – источник статей (RSS/Atom-лента, хаб, Q&A и т.д.)
+// Каждый источник опрашивается независимо, со своим интервалом и своим списком обработанных статей
+type Source interface {
+	// Name возвращает уникальное имя источника
+	Name() string
+	// Interval возвращает период опроса источника
+	Interval() time.Duration
+	// Fetch возвращает записи источника
+	Fetch() ([]*gofeed.Item, error)
+	// Normalize преобразует запись источника в статью
+	Normalize(item *gofeed.Item) article
+}
+
+// rssSource – источник, основанный на RSS/Atom-ленте
+type rssSource struct {
+	name string
+	url  string
+	// изменяется и читается атомарно (см. setInterval)
+	interval time.Duration
+}
+
+// NewRSSSource возвращает источник для произвольной RSS/Atom-ленты
+func NewRSSSource(name, url string, interval time.Duration) Source {
+	return &rssSource{name: name, url: url, interval: interval}
+}
+
+// NewHabrSource возвращает источник всех статей Habr для языка lang ("ru" или "en")
+func NewHabrSource(lang string, interval time.Duration) Source {
+	url, listingURL := allRuHabrArticlesURL, allRuHabrListingURL
+	if lang == "en" {
+		url, listingURL = allEnHabrArticlesURL, allEnHabrListingURL
+	}
+	return &habrSource{
+		rssSource:  rssSource{name: "habr_" + lang, url: url, interval: interval},
+		listingURL: listingURL,
+	}
+}
+
+func (s *rssSource) Name() string {
+	return s.name
+}
+
+func (s *rssSource) Interval() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&s.interval)))
+}
+
+// setInterval изменяет период опроса источника
+func (s *rssSource) setInterval(interval time.Duration) {
+	atomic.StoreInt64((*int64)(&s.interval), int64(interval))
+}
+
+func (s *rssSource) Fetch() ([]*gofeed.Item, error) {
+	feed, err := getRSS(s.url)
+	if err != nil {
+		return nil, err
+	}
+	return feed.Items, nil
+}
+
+// normalizeTag форматирует тег от "Some Tag" к "some_tag"
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Replace(tag, " ", "_", -1))
+}
+
+func (s *rssSource) Normalize(item *gofeed.Item) article {
+	// Создание списка тегов статьи
+	var tags []string
+	for _, tag := range item.Categories {
+		tags = append(tags, normalizeTag(tag))
+	}
+
+	message := formatString(messageText,
+		map[string]string{
+			"title": html.EscapeString(item.Title),
+			"link":  item.Link})
+
+	var author string
+	if item.Author != nil {
+		author = normalizeAuthor(item.Author.Name)
+	}
+
+	var published time.Time
+	if item.PublishedParsed != nil {
+		published = *item.PublishedParsed
+	}
+
+	return article{title: item.Title, tags: tags, link: item.Link, message: message,
+		description: stripHTML(item.Description), author: author, company: getCompany(item.Link), published: published}
+}
+
+// Регулярное выражение для получения номера поста из ссылки на статью Habr
+// (например, https://habr.com/ru/post/123456/ или https://habr.com/ru/company/yandex/blog/123456/)
+var habrPostIDRegexp = regexp.MustCompile(`(?:habrahabr\.ru|habr\.com|habr\.ru)/(?:.*/)?(\d+)/?(?:[?#].*)?$`)
+
+// articleKey возвращает ключ статьи для списка обработанных статей: номер поста для Habr, для остальных – ссылку
+// Номер поста не меняется при смене домена или языка в ссылке
+func articleKey(link string) string {
+	if match := habrPostIDRegexp.FindStringSubmatch(link); match != nil {
+		return match[1]
+	}
+	return link
+}
+
+// itemKeys возвращает ключи записей
+func itemKeys(items []*gofeed.Item) []string {
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, articleKey(item.Link))
+	}
+	return keys
+}
+
+// filterNew возвращает только необработанные записи источника.
+// Если источник обрабатывается впервые, то все записи считаются старыми (чтобы не присылать всю ленту сразу)
+func filterNew(name string, items []*gofeed.Item) ([]*gofeed.Item, error) {
+	seen, existed, err := userdb.GetSeen(name, itemKeys(items))
+	if err != nil {
+		return nil, err
+	}
+	if !existed {
+		return nil, nil
+	}
+
+	var newItems []*gofeed.Item
+	for _, item := range items {
+		if !seen[articleKey(item.Link)] {
+			newItems = append(newItems, item)
+		}
+	}
+	return newItems, nil
+}
+
+// Файл (в папке с данными), в котором раньше хранились ссылки на уже обработанные статьи
+const lastArticlesFile = "lastArticles.json"
+
+// Ключ, под которым в lastArticles.json хранились статьи до появления источников
+const legacyArticlesStateKey = "habr"
+
+// importLastArticles переносит обработанные статьи из lastArticles.json в базу данных (один раз).
+// Статьи, хранившиеся под старым общим ключом, переносятся во все зарегистрированные источники без своего списка.
+// После переноса файл переименовывается, чтобы не импортировать его повторно
+func importLastArticles(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Если в базе данных уже есть статьи, файл остался от старой версии и не нужен
+	has, err := userdb.HasSeen()
+	if err != nil {
+		return err
+	}
+
+	if !has {
+		links := make(map[string][]string)
+		if err := json.Unmarshal(raw, &links); err != nil {
+			return err
+		}
+
+		if legacy, ok := links[legacyArticlesStateKey]; ok {
+			delete(links, legacyArticlesStateKey)
+			for _, name := range sources.names() {
+				if _, ok := links[name]; !ok {
+					links[name] = legacy
+				}
+			}
+		}
+
+		now := time.Now()
+		for name, items := range links {
+			keys := make([]string, 0, len(items))
+			for _, link := range items {
+				keys = append(keys, articleKey(link))
+			}
+			if err := userdb.MarkSeen(name, keys, now); err != nil {
+				return err
+			}
+		}
+		logging.LogInfo("Обработанные статьи перенесены из %s в базу данных", path)
+	}
+
+	return os.Rename(path, path+".imported")
+}
+
+// cleanSeenArticles удаляет статьи, которые давно пропали из лент источников
+func cleanSeenArticles() {
+	period := config.Get().SeenTTL
+	_, err := userdb.CleanSeen(period)
+	if err != nil {
+		logging.LogMinorError("cleanSeenArticles", "попытка удалить старые статьи", err)
+	}
+}
+
+// sourceRegistry хранит зарегистрированные источники и управляет их опросом
+type sourceRegistry struct {
+	mu      sync.Mutex
+	sources map[string]Source
+	// каналы для остановки опроса запущенных источников
+	stops map[string]chan struct{}
+	// канал для новых статей. nil, пока опрос не запущен
+	articles chan<- article
+	// запущенные опросы источников (см. stop)
+	wg sync.WaitGroup
+	// закрывается и заменяется при изменении интервалов опроса (см. setInterval)
+	intervalChanged chan struct{}
+}
+
+var sources = sourceRegistry{
+	sources:         make(map[string]Source),
+	stops:           make(map[string]chan struct{}),
+	intervalChanged: make(chan struct{}),
+}
+
+// RegisterSource добавляет источник статей. Если опрос источников уже запущен, источник начинает опрашиваться сразу
+func RegisterSource(src Source) error {
+	return sources.register(src)
+}
+
+// UnregisterSource останавливает опрос источника и удаляет его
+func UnregisterSource(name string) {
+	sources.unregister(name)
+}
+
+func (r *sourceRegistry) register(src Source) error {
+	if src.Interval() <= 0 {
+		return errors.New("интервал опроса источника '" + src.Name() + "' должен быть положительным")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sources[src.Name()]; ok {
+		return errors.New("источник '" + src.Name() + "' уже зарегистрирован")
+	}
+	r.sources[src.Name()] = src
+
+	if r.articles != nil {
+		r.startSource(src)
+	}
+	return nil
+}
+
+func (r *sourceRegistry) unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stop, ok := r.stops[name]; ok {
+		close(stop)
+		delete(r.stops, name)
+	}
+	if _, ok := r.sources[name]; ok {
+		delete(r.sources, name)
+		if err := userdb.DeleteSeenSource(name); err != nil {
+			logging.LogMinorError("unregister", "попытка удалить обработанные статьи источника "+name, err)
+		}
+	}
+}
+
+// names возвращает имена зарегистрированных источников
+func (r *sourceRegistry) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.sources))
+	for name := range r.sources {
+		names = append(names, name)
+	}
+	return names
+}
+
+// start запускает опрос всех зарегистрированных источников. Новые статьи отправляются в канал articles
+func (r *sourceRegistry) start(articles chan<- article) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.articles = articles
+	for _, src := range r.sources {
+		r.startSource(src)
+	}
+}
+
+// startSource запускает опрос источника. Должна вызываться под r.mu
+func (r *sourceRegistry) startSource(src Source) {
+	stop := make(chan struct{})
+	r.stops[src.Name()] = stop
+	articles := r.articles
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.poll(src, articles, stop)
+	}()
+}
+
+// stop останавливает опрос всех источников и ждёт завершения текущих запросов.
+// Источники остаются зарегистрированными
+func (r *sourceRegistry) stop() {
+	r.mu.Lock()
+	for name, stop := range r.stops {
+		close(stop)
+		delete(r.stops, name)
+	}
+	r.articles = nil
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
+
+// setInterval изменяет период опроса всех источников, которые это поддерживают.
+// Время следующего опроса пересчитывается сразу, без ожидания текущего периода
+func (r *sourceRegistry) setInterval(interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, src := range r.sources {
+		if s, ok := src.(interface{ setInterval(time.Duration) }); ok {
+			s.setInterval(interval)
+		}
+	}
+	close(r.intervalChanged)
+	r.intervalChanged = make(chan struct{})
+}
+
+// poll опрашивает источник с периодичностью src.Interval(), пока не будет закрыт канал stop
+func (r *sourceRegistry) poll(src Source, articles chan<- article, stop <-chan struct{}) {
+	for {
+		started := time.Now()
+		r.fetchNew(src, articles, stop)
+
+		for waiting := true; waiting; {
+			r.mu.Lock()
+			changed := r.intervalChanged
+			r.mu.Unlock()
+
+			timer := time.NewTimer(time.Until(started.Add(src.Interval())))
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-changed:
+				timer.Stop()
+			case <-timer.C:
+				waiting = false
+			}
+		}
+	}
+}
+
+// fetchNew отправляет в канал только новые статьи источника
+// Логика работы:
+// 1) Получаем все записи источника
+// 2) Удаляем записи, которые уже есть в списке обработанных статей (по номеру поста или URL)
+// 3) Если ни одна запись не была обработана раньше, восстанавливаем пропавшие из ленты статьи (см. Backfiller)
+// 4) Отправляем статьи в канал (старые раньше)
+// Если опрос остановлен до отправки всех статей, список обработанных статей не обновляется,
+// и статьи будут получены снова после перезапуска
+func (r *sourceRegistry) fetchNew(src Source, articles chan<- article, stop <-chan struct{}) {
+	started := time.Now()
+	items, err := src.Fetch()
+	feedFetchDuration.Observe(time.Since(started).Seconds(), src.Name())
+	if err != nil {
+		feedFetchFailures.Inc(src.Name())
+		logging.LogMinorError("fetchNew", "попытка получить статьи источника "+src.Name(), err)
+		return
+	}
+	fetchSucceeded()
+
+	sortItems(items)
+
+	newItems, err := filterNew(src.Name(), items)
+	if err != nil {
+		logging.LogMinorError("fetchNew", "попытка получить обработанные статьи источника "+src.Name(), err)
+		return
+	}
+	// Пропуски ищутся только у источников, которые умеют их восстанавливать: пользовательская лента
+	// может целиком обновляться между опросами, и для неё это не пропуск
+	if b, ok := src.(Backfiller); ok && isGap(items, newItems) {
+		atomic.AddUint64(&feedGaps, 1)
+		newItems = backfill(src.Name(), b, newItems)
+		items = newItems
+	}
+	articlesDiscovered.Add(float64(len(newItems)), src.Name())
+
+	for i := len(newItems) - 1; i >= 0; i-- {
+		select {
+		case articles <- src.Normalize(newItems[i]):
+		case <-stop:
+			return
+		}
+	}
+
+	// Обновляем список обработанных статей. Время обновляется у всех статей ленты,
+	// поэтому удаляются только статьи, которые давно пропали из ленты
+	err = userdb.MarkSeen(src.Name(), itemKeys(items), time.Now())
+	if err != nil {
+		logging.LogMinorError("fetchNew", "попытка сохранить обработанные статьи источника "+src.Name(), err)
+	}
+}
+
+// sortItems сортирует записи в порядке убывания по времени, т.е новые раньше. Если у записи нет даты публикации,
+// используется дата обновления. Записи без дат идут в конце и остаются на своих местах относительно друг друга
+func sortItems(items []*gofeed.Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := itemTime(items[i]), itemTime(items[j])
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.After(*b)
+	})
+}
+
+// itemTime возвращает дату публикации записи или, если её нет, дату обновления
+func itemTime(item *gofeed.Item) *time.Time {
+	if item.PublishedParsed != nil {
+		return item.PublishedParsed
+	}
+	return item.UpdatedParsed
+}
@@ -0,0 +1,32 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestSortItems(t *testing.T) {
+	date := func(day int) *time.Time {
+		t := time.Date(2019, 5, day, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	items := []*gofeed.Item{
+		{Link: "no-date-1"},
+		{Link: "day-1", PublishedParsed: date(1)},
+		{Link: "updated-day-4", UpdatedParsed: date(4)},
+		{Link: "no-date-2"},
+		{Link: "day-3", PublishedParsed: date(3)},
+		{Link: "day-2", PublishedParsed: date(2), UpdatedParsed: date(5)},
+	}
+	sortItems(items)
+
+	want := []string{"updated-day-4", "day-3", "day-2", "day-1", "no-date-1", "no-date-2"}
+	for i, item := range items {
+		if item.Link != want[i] {
+			t.Fatalf("position %d: got %s, want %s", i, item.Link, want[i])
+		}
+	}
+}
@@ -1,9 +1,21 @@
 package bot
 
+import "time"
+
 // article содержит информацию о статье
 type article struct {
 	title   string
 	link    string
 	tags    []string
 	message string
+	// текст описания статьи из RSS-ленты без html-тегов
+	description string
+	// имя автора в нижнем регистре
+	author string
+	// компания, в блоге которой опубликована статья (в нижнем регистре). Пустая, если статья не из блога компании
+	company string
+	// ссылка на пользовательскую ленту, из которой получена статья. Пустая для общих источников
+	feed string
+	// время публикации. Нулевое, если в ленте его нет
+	published time.Time
 }
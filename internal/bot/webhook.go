@@ -0,0 +1,148 @@
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/config"
+	"github.com/ShoshinNikita/habrahabr-bot-go/internal/logging"
+)
+
+const (
+	// Заголовок, в котором Telegram передаёт секретный токен, указанный при вызове setWebhook
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// Максимальное количество одновременных запросов от Telegram
+	webhookMaxConnections = 40
+	// Максимальный размер тела запроса
+	maxUpdateSize = 1 << 20
+	// Сколько времени при остановке ждать завершения обработки запросов
+	webhookShutdownTimeout = 5 * time.Second
+)
+
+// Типы обновлений, которые обрабатывает бот
+const allowedUpdates = `["message","callback_query","inline_query"]`
+
+// webhookHandler принимает обновления от Telegram и передаёт их в канал updates.
+// Telegram получает ответ только после того, как обновление принято в обработку,
+// поэтому при остановке бота обновления не теряются: Telegram повторит запрос позже
+type webhookHandler struct {
+	secret  string
+	updates chan<- tgbotapi.Update
+	stop    <-chan struct{}
+}
+
+func (h webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Запросы без правильного секретного токена отправлены не Telegram
+	token := r.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) != 1 {
+		logging.LogInfo("Webhook: запрос с неверным секретным токеном от %s", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var update tgbotapi.Update
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update)
+	if err != nil {
+		logging.LogMinorError("webhook", "попытка распарсить обновление", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case h.updates <- update:
+		w.WriteHeader(http.StatusOK)
+	case <-h.stop:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	}
+}
+
+// setWebhook регистрирует webhook с секретным токеном
+// (в используемой версии telegram-bot-api нет поддержки secret_token, поэтому запрос формируется вручную)
+func (bot *Bot) setWebhook(webhookURL, secret string) error {
+	params := url.Values{}
+	params.Set("url", webhookURL)
+	params.Set("secret_token", secret)
+	params.Set("max_connections", strconv.Itoa(webhookMaxConnections))
+	params.Set("allowed_updates", allowedUpdates)
+
+	// MakeRequest возвращает ошибку, если Telegram ответил ok: false
+	_, err := bot.botAPI.MakeRequest("setWebhook", params)
+	return err
+}
+
+// deleteWebhook удаляет webhook. Без этого Telegram не отдаёт обновления через getUpdates
+func (bot *Bot) deleteWebhook() error {
+	_, err := bot.botAPI.MakeRequest("deleteWebhook", url.Values{})
+	return err
+}
+
+// listenWebhook запускает HTTP-сервер для приёма обновлений и регистрирует webhook.
+// Если указаны сертификат и ключ, сервер использует TLS, иначе ожидается, что TLS терминируется на reverse proxy.
+// После отмены ctx webhook удаляется, а сервер останавливается
+func (bot *Bot) listenWebhook(ctx context.Context) (tgbotapi.UpdatesChannel, error) {
+	webhookURL, err := url.Parse(config.Get().WebhookURL)
+	if err != nil {
+		return nil, err
+	}
+	path := webhookURL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	updates := make(chan tgbotapi.Update)
+	mux := http.NewServeMux()
+	mux.Handle(path, webhookHandler{secret: config.Get().WebhookSecret, updates: updates, stop: ctx.Done()})
+
+	server := &http.Server{
+		Addr:         config.Get().Listen,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		var err error
+		if config.Get().TLSCert != "" {
+			err = server.ListenAndServeTLS(config.Get().TLSCert, config.Get().TLSKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logging.LogFatalError("listenWebhook", "попытка запустить HTTP-сервер", err)
+		}
+	}()
+
+	err = bot.setWebhook(config.Get().WebhookURL, config.Get().WebhookSecret)
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+	logging.LogInfo("Webhook: %s, адрес сервера: %s", webhookURL.Host+path, config.Get().Listen)
+
+	go func() {
+		<-ctx.Done()
+		if err := bot.deleteWebhook(); err != nil {
+			logging.LogMinorError("listenWebhook", "попытка удалить webhook", err)
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logging.LogMinorError("listenWebhook", "попытка остановить HTTP-сервер", err)
+		}
+	}()
+
+	return updates, nil
+}
@@ -3,33 +3,361 @@ package config
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
 // ConfigurationData содержит конфигурационную информацию
 type ConfigurationData struct {
-	BotToken string // token бота
-	Delay    uint64 // в секундах
-	Rate     uint64 // в милисекундах
+	BotToken   string        // token бота
+	TokenFile  string        // файл с token бота (используется, если token не указан)
+	Delay      time.Duration // задержка между обновлениями статей
+	Rate       time.Duration // минимальная задержка между отправкой сообщений
+	PurgeAfter time.Duration // через сколько удалять неактивных пользователей (0 – не удалять)
+	SeenTTL    time.Duration // сколько хранить статьи, пропавшие из лент источников
+	ArchiveTTL time.Duration // сколько хранить статьи в архиве для поиска
+	DataDir    string        // папка с базой данных и остальными файлами бота
+
+	WebhookURL    string // публичный адрес webhook (пустой – Long Pooling)
+	WebhookSecret string // секретный токен, которым Telegram подписывает запросы к webhook
+	Listen        string // адрес HTTP-сервера для webhook
+	TLSCert       string // сертификат для HTTPS (пустой – TLS терминируется на reverse proxy)
+	TLSKey        string // ключ сертификата
+
+	MetricsListen string // адрес HTTP-сервера метрик и проверок состояния (пустой – сервер не запускается)
+
+	Admins []int64 // id администраторов (могут использовать /reload)
+}
+
+// Текущая конфигурация. Может измениться при перезагрузке (см. Apply), поэтому доступна только через Get
+var (
+	mu   sync.RWMutex
+	data ConfigurationData
+)
+
+// Get возвращает текущую конфигурацию
+func Get() ConfigurationData {
+	mu.RLock()
+	defer mu.RUnlock()
+	return data
 }
 
-// Data содержит конфигурационные данные
-var Data ConfigurationData
+// CheckConfig – true, если программа запущена с флагом -check-config: нужно только проверить конфигурацию
+var CheckConfig bool
 
-// GetConfigInfo парсит флаги и заполняет Data
-func GetConfigInfo() error {
-	var nanoseconds uint64
-	flag.StringVar(&Data.BotToken, "bToken", "", "token of a bot")
-	flag.Uint64Var(&nanoseconds, "delay", 1200000000000, "delay of getting articles (nanoseconds)")
-	flag.Uint64Var(&Data.Rate, "rate", 500, "delay between sending of messages (milliseconds)")
+// Допустимые символы секретного токена webhook (ограничение Telegram)
+var webhookSecretRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// Префикс переменных окружения. Имя переменной – префикс и ключ параметра в верхнем регистре (HABR_BOT_DELAY)
+const envPrefix = "HABR_BOT_"
 
+// option – параметр, который можно указать флагом, в конфигурационном файле или в переменной окружения
+type option struct {
+	flag   string // имя флага
+	key    string // ключ в конфигурационном файле
+	usage  string
+	value  flag.Value
+	secret bool // значение не выводится в -check-config
+	// параметр применяется только при запуске программы
+	restartOnly bool
+}
+
+func (o option) env() string {
+	return envPrefix + strings.ToUpper(o.key)
+}
+
+// Значения по-умолчанию
+var defaults = ConfigurationData{
+	Delay:         20 * time.Minute,
+	Rate:          35 * time.Millisecond,
+	PurgeAfter:    90 * day,
+	SeenTTL:       30 * day,
+	ArchiveTTL:    365 * day,
+	DataDir:       "data",
+	Listen:        ":8443",
+	MetricsListen: "127.0.0.1:9090",
+}
+
+// options возвращает все параметры, значения которых хранятся в d
+func options(d *ConfigurationData) []option {
+	return []option{
+		{flag: "bToken", key: "token", value: stringValue{&d.BotToken}, secret: true, restartOnly: true,
+			usage: "token of a bot"},
+		{flag: "token-file", key: "token_file", value: stringValue{&d.TokenFile}, restartOnly: true,
+			usage: "file with the token of a bot (used if the token isn't set)"},
+		{flag: "delay", key: "delay", value: durationValue{&d.Delay, time.Nanosecond},
+			usage: "delay of getting articles (duration, a bare number is nanoseconds)"},
+		{flag: "rate", key: "rate", value: durationValue{&d.Rate, time.Millisecond},
+			usage: "minimal delay between sending of any messages (duration, a bare number is milliseconds)"},
+		{flag: "purge", key: "purge", value: durationValue{&d.PurgeAfter, day},
+			usage: "delete users who blocked the bot after this period (a bare number is days, 0 – never)"},
+		{flag: "seen-ttl", key: "seen_ttl", value: durationValue{&d.SeenTTL, day},
+			usage: "keep processed articles that left the source feeds for this period (a bare number is days)"},
+		{flag: "archive-ttl", key: "archive_ttl", value: durationValue{&d.ArchiveTTL, day},
+			usage: "keep articles in the search archive for this period (a bare number is days)"},
+		{flag: "data-dir", key: "data_dir", value: stringValue{&d.DataDir}, restartOnly: true,
+			usage: "directory with the database and other files of the bot"},
+		{flag: "webhook", key: "webhook", value: stringValue{&d.WebhookURL}, restartOnly: true,
+			usage: "public https url of the webhook (empty – long pooling)"},
+		{flag: "webhook-secret", key: "webhook_secret", value: stringValue{&d.WebhookSecret}, secret: true, restartOnly: true,
+			usage: "secret token for verifying webhook requests (A-Z, a-z, 0-9, _ and -)"},
+		{flag: "listen", key: "listen", value: stringValue{&d.Listen}, restartOnly: true,
+			usage: "address of the webhook http server"},
+		{flag: "tls-cert", key: "tls_cert", value: stringValue{&d.TLSCert}, restartOnly: true,
+			usage: "tls certificate of the webhook server (empty – plain http behind a reverse proxy)"},
+		{flag: "tls-key", key: "tls_key", value: stringValue{&d.TLSKey}, restartOnly: true,
+			usage: "tls key of the webhook server"},
+		{flag: "metrics-listen", key: "metrics_listen", value: stringValue{&d.MetricsListen}, restartOnly: true,
+			usage: "address of the http server with prometheus metrics, /healthz and /readyz (empty – disabled)"},
+		{flag: "admins", key: "admins", value: idsValue{&d.Admins},
+			usage: "comma-separated ids of admins"},
+	}
+}
+
+// Путь до конфигурационного файла и значения флагов, указанных при запуске. Нужны для перезагрузки конфигурации
+var (
+	configPath string
+	flagValues = make(map[string]string)
+)
+
+// GetConfigInfo парсит флаги и загружает конфигурацию. Приоритет (от низкого к высокому): значения по-умолчанию,
+// конфигурационный файл, переменные окружения, флаги
+func GetConfigInfo() error {
+	parsed := defaults
+	flag.StringVar(&configPath, "config", os.Getenv(envPrefix+"CONFIG"), "path to a yaml config file (env "+envPrefix+"CONFIG)")
+	flag.BoolVar(&CheckConfig, "check-config", false, "check the configuration, print it and exit")
+	for _, o := range options(&parsed) {
+		flag.Var(o.value, o.flag, fmt.Sprintf("%s (config key %s, env %s)", o.usage, o.key, o.env()))
+	}
 	flag.Parse()
 
-	// Получаем задержку в секундах
-	Data.Delay = nanoseconds / 1e9
+	flag.Visit(func(f *flag.Flag) {
+		flagValues[f.Name] = f.Value.String()
+	})
+
+	d, err := Load()
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	data = d
+	mu.Unlock()
+	return nil
+}
+
+// Load заново читает конфигурационный файл и переменные окружения и возвращает проверенную конфигурацию.
+// Текущая конфигурация не изменяется (см. Apply)
+func Load() (ConfigurationData, error) {
+	d := defaults
+	opts := options(&d)
+
+	if configPath != "" {
+		err := loadFile(configPath, opts)
+		if err != nil {
+			return d, err
+		}
+	}
+
+	for _, o := range opts {
+		value, ok := os.LookupEnv(o.env())
+		if !ok {
+			continue
+		}
+		if err := o.value.Set(value); err != nil {
+			return d, fmt.Errorf("env %s: %s", o.env(), err)
+		}
+	}
+
+	for _, o := range opts {
+		value, ok := flagValues[o.flag]
+		if !ok {
+			continue
+		}
+		if err := o.value.Set(value); err != nil {
+			return d, fmt.Errorf("flag -%s: %s", o.flag, err)
+		}
+	}
+
+	if d.BotToken == "" && d.TokenFile != "" {
+		token, err := ioutil.ReadFile(d.TokenFile)
+		if err != nil {
+			return d, fmt.Errorf("token_file: %s", err)
+		}
+		d.BotToken = strings.TrimSpace(string(token))
+	}
+
+	return d, validate(d)
+}
+
+// Apply заменяет текущую конфигурацию на d и возвращает список изменений.
+// Параметры, которые применяются только при запуске, не изменяются
+func Apply(d ConfigurationData) []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var changes []string
+	oldOpts, newOpts := options(&data), options(&d)
+	for i := range oldOpts {
+		oldValue, newValue := oldOpts[i].value.String(), newOpts[i].value.String()
+		if oldValue == newValue {
+			continue
+		}
+
+		o := oldOpts[i]
+		if o.secret {
+			oldValue, newValue = "***", "***"
+		}
+		if o.restartOnly {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s (нужен перезапуск)", o.key, oldValue, newValue))
+			// Сохраняем старое значение
+			newOpts[i].value.Set(oldOpts[i].value.String())
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", o.key, oldValue, newValue))
+	}
+
+	data = d
+	return changes
+}
+
+// loadFile читает параметры из yaml-файла
+func loadFile(path string, opts []option) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %s", err)
+	}
+
+	var values map[string]interface{}
+	err = yaml.Unmarshal(data, &values)
+	if err != nil {
+		return fmt.Errorf("config file %s: %s", path, err)
+	}
+
+	byKey := make(map[string]option, len(opts))
+	for _, o := range opts {
+		byKey[o.key] = o
+	}
 
-	if Data.BotToken == "" {
-		return errors.New("botToken is missed")
+	// Ключи сортируются, чтобы ошибки не зависели от порядка обхода map
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		o, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown key %q", path, key)
+		}
+		var value string
+		switch v := values[key].(type) {
+		case nil:
+			continue
+		case string, int, float64, bool:
+			value = fmt.Sprint(v)
+		case []interface{}:
+			// Список (например, admins) передаётся через запятую
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			value = strings.Join(items, ",")
+		default:
+			return fmt.Errorf("config file %s: %s must be a single value", path, key)
+		}
 
+		if err := o.value.Set(value); err != nil {
+			return fmt.Errorf("config file %s: %s: %s", path, key, err)
+		}
+	}
 	return nil
 }
+
+// validate проверяет значения параметров и возвращает все найденные ошибки
+func validate(d ConfigurationData) error {
+	var problems []string
+
+	if d.BotToken == "" {
+		problems = append(problems, "token is missed: set -bToken, "+envPrefix+"TOKEN, token or token_file in the config file")
+	}
+	if d.Delay <= 0 {
+		problems = append(problems, "delay must be positive")
+	}
+	if d.Rate <= 0 {
+		problems = append(problems, "rate must be positive")
+	}
+	if d.SeenTTL <= 0 {
+		problems = append(problems, "seen-ttl must be positive")
+	}
+	if d.ArchiveTTL <= 0 {
+		problems = append(problems, "archive-ttl must be positive")
+	}
+
+	if info, err := os.Stat(d.DataDir); err != nil {
+		problems = append(problems, "data-dir: "+err.Error())
+	} else if !info.IsDir() {
+		problems = append(problems, "data-dir: "+d.DataDir+" is not a directory")
+	}
+
+	if d.WebhookURL != "" {
+		u, err := url.Parse(d.WebhookURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			problems = append(problems, "webhook must be an https url")
+		}
+		if !webhookSecretRegexp.MatchString(d.WebhookSecret) {
+			problems = append(problems, "webhook-secret must be 1-256 characters A-Z, a-z, 0-9, _ or -")
+		}
+	}
+
+	if d.WebhookURL != "" && d.MetricsListen != "" && d.MetricsListen == d.Listen {
+		problems = append(problems, "metrics-listen must differ from listen")
+	}
+
+	if (d.TLSCert == "") != (d.TLSKey == "") {
+		problems = append(problems, "tls-cert and tls-key must be set together")
+	}
+	for _, path := range []string{d.TLSCert, d.TLSKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			problems = append(problems, "tls: "+err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// DataPath возвращает путь к файлу name в папке с данными бота
+func DataPath(name string) string {
+	return filepath.Join(Get().DataDir, name)
+}
+
+// Describe возвращает итоговую конфигурацию (секретные значения скрыты)
+func Describe() string {
+	d := Get()
+	var b strings.Builder
+	for _, o := range options(&d) {
+		value := o.value.String()
+		if o.secret && value != "" {
+			value = "***"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", o.key, value)
+	}
+	return b.String()
+}
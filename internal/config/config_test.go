@@ -0,0 +1,202 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setEnv задаёт переменную окружения до конца теста
+func setEnv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// setSources задаёт конфигурационный файл и флаги до конца теста
+func setSources(t *testing.T, file string, flags map[string]string) {
+	oldPath, oldFlags := configPath, flagValues
+	t.Cleanup(func() {
+		configPath, flagValues = oldPath, oldFlags
+	})
+
+	configPath = ""
+	if file != "" {
+		configPath = filepath.Join(t.TempDir(), "config.yaml")
+		if err := ioutil.WriteFile(configPath, []byte(file), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flagValues = flags
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dataDir := t.TempDir()
+	setSources(t, strings.Join([]string{
+		"token: file-token",
+		"data_dir: " + dataDir,
+		"delay: 10m",
+		"rate: 50ms",
+		"purge: 7d",
+		"admins: [1, 2]",
+	}, "\n"), map[string]string{
+		"delay": "1m",
+	})
+	setEnv(t, envPrefix+"DELAY", "5m")
+	setEnv(t, envPrefix+"RATE", "100")
+
+	d, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"flag over env and file", d.Delay, time.Minute},
+		{"env over file", d.Rate, 100 * time.Millisecond},
+		{"file over default", d.PurgeAfter, 7 * day},
+		{"file", d.BotToken, "file-token"},
+		{"list in file", d.Admins, []int64{1, 2}},
+		{"default", d.SeenTTL, defaults.SeenTTL},
+		{"default", d.Listen, defaults.Listen},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dataDir := t.TempDir()
+
+	tests := []struct {
+		name string
+		file string
+		env  string
+		err  string
+	}{
+		{"unknown key", "token: t\ndata_dir: " + dataDir + "\nunknown: 1", "", `unknown key "unknown"`},
+		{"invalid value in file", "token: t\ndata_dir: " + dataDir + "\ndelay: soon", "", "delay: invalid duration"},
+		{"invalid env", "token: t\ndata_dir: " + dataDir, "-1s", "env " + envPrefix + "DELAY"},
+		{"missed token", "data_dir: " + dataDir, "", "token is missed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setSources(t, tt.file, nil)
+			if tt.env != "" {
+				setEnv(t, envPrefix+"DELAY", tt.env)
+			}
+
+			_, err := Load()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error with %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestDurationValue(t *testing.T) {
+	tests := []struct {
+		s    string
+		unit time.Duration
+		want time.Duration
+	}{
+		// Старые значения флагов без единиц измерения
+		{"1200000000000", time.Nanosecond, 20 * time.Minute},
+		{"35", time.Millisecond, 35 * time.Millisecond},
+		{"90", day, 90 * day},
+		{"0", day, 0},
+		// Новый формат не зависит от unit
+		{"20m", time.Nanosecond, 20 * time.Minute},
+		{"1h30m", day, 90 * time.Minute},
+		{"35ms", time.Millisecond, 35 * time.Millisecond},
+		{"30d", time.Millisecond, 30 * day},
+		{" 7d ", day, 7 * day},
+	}
+	for _, tt := range tests {
+		var d time.Duration
+		if err := (durationValue{&d, tt.unit}).Set(tt.s); err != nil {
+			t.Errorf("Set(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+		if d != tt.want {
+			t.Errorf("Set(%q) with unit %s = %s, want %s", tt.s, tt.unit, d, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "soon", "-1s", "-5", "1.5d", "d"} {
+		var d time.Duration
+		if err := (durationValue{&d, day}).Set(s); err == nil {
+			t.Errorf("Set(%q): expected an error", s)
+		}
+	}
+
+	for d, want := range map[time.Duration]string{
+		30 * day:              "30d",
+		36 * time.Hour:        "36h0m0s",
+		35 * time.Millisecond: "35ms",
+	} {
+		if got := (durationValue{p: &d}).String(); got != want {
+			t.Errorf("String(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	old := Get()
+	t.Cleanup(func() {
+		mu.Lock()
+		data = old
+		mu.Unlock()
+	})
+
+	current := defaults
+	current.BotToken = "old-token"
+	mu.Lock()
+	data = current
+	mu.Unlock()
+
+	next := current
+	next.BotToken = "new-token"
+	next.DataDir = "other"
+	next.Delay = 5 * time.Minute
+	next.Admins = []int64{1}
+
+	changes := Apply(next)
+	want := []string{
+		"token: *** -> *** (нужен перезапуск)",
+		"delay: 20m0s -> 5m0s",
+		"data_dir: data -> other (нужен перезапуск)",
+		"admins:  -> 1",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes:\n got %q\nwant %q", changes, want)
+	}
+
+	d := Get()
+	if d.BotToken != "old-token" || d.DataDir != "data" {
+		t.Errorf("restart-only options must keep old values: token %q, data_dir %q", d.BotToken, d.DataDir)
+	}
+	if d.Delay != 5*time.Minute || !reflect.DeepEqual(d.Admins, []int64{1}) {
+		t.Errorf("other options must be applied: delay %s, admins %v", d.Delay, d.Admins)
+	}
+
+	// До перезапуска повторное применение той же конфигурации напоминает только о параметрах запуска
+	want = []string{want[0], want[2]}
+	if changes := Apply(next); !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes:\n got %q\nwant %q", changes, want)
+	}
+}
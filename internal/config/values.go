@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stringValue – строковый параметр
+type stringValue struct {
+	p *string
+}
+
+func (v stringValue) Set(s string) error {
+	*v.p = s
+	return nil
+}
+
+func (v stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+// durationValue – продолжительность в формате time.ParseDuration ("20m", "1h30m") или в днях ("30d").
+// Число без единиц измерения считается в единицах unit, чтобы старые значения флагов (-delay в наносекундах,
+// -rate в миллисекундах, -purge в днях) продолжали работать
+type durationValue struct {
+	p    *time.Duration
+	unit time.Duration
+}
+
+func (v durationValue) Set(s string) error {
+	d, err := parseDuration(strings.TrimSpace(s), v.unit)
+	if err != nil {
+		return err
+	}
+	*v.p = d
+	return nil
+}
+
+func (v durationValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return formatDuration(*v.p)
+}
+
+const day = 24 * time.Hour
+
+func parseDuration(s string, unit time.Duration) (time.Duration, error) {
+	if n, err := strconv.ParseUint(s, 10, 63); err == nil {
+		return time.Duration(n) * unit, nil
+	}
+
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseUint(strings.TrimSuffix(s, "d"), 10, 32)
+		if err != nil {
+			return 0, errors.New("invalid number of days " + strconv.Quote(s))
+		}
+		return time.Duration(n) * day, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New("invalid duration " + strconv.Quote(s) + " (examples: 35ms, 20m, 1h30m, 30d)")
+	}
+	if d < 0 {
+		return 0, errors.New("duration " + strconv.Quote(s) + " must not be negative")
+	}
+	return d, nil
+}
+
+func formatDuration(d time.Duration) string {
+	if d >= day && d%day == 0 {
+		return strconv.FormatInt(int64(d/day), 10) + "d"
+	}
+	return d.String()
+}
+
+// idsValue – список id через запятую
+type idsValue struct {
+	p *[]int64
+}
+
+func (v idsValue) Set(s string) error {
+	var ids []int64
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return errors.New("invalid id " + strconv.Quote(field))
+		}
+		ids = append(ids, id)
+	}
+	*v.p = ids
+	return nil
+}
+
+func (v idsValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	ids := make([]string, 0, len(*v.p))
+	for _, id := range *v.p {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,270 @@
+// Package metrics реализует метрики в текстовом формате Prometheus
+// (https://prometheus.io/docs/instrumenting/exposition_formats/)
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector – метрика, которая умеет выводить свои значения
+type collector interface {
+	write(w io.Writer)
+}
+
+// Зарегистрированные метрики (в порядке создания)
+var registry struct {
+	sync.Mutex
+	collectors []collector
+}
+
+func register(c collector) {
+	registry.Lock()
+	registry.collectors = append(registry.collectors, c)
+	registry.Unlock()
+}
+
+// Handler возвращает обработчик, который выводит значения всех метрик
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry.Lock()
+		collectors := append([]collector(nil), registry.collectors...)
+		registry.Unlock()
+
+		var buf bytes.Buffer
+		for _, c := range collectors {
+			c.write(&buf)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// Counter – счётчик с метками
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter создаёт и регистрирует счётчик. Значения меток передаются в Inc и Add в том же порядке
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc увеличивает счётчик на 1
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add увеличивает счётчик на v
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		writeValue(w, c.name, key, c.values[key])
+	}
+}
+
+// Histogram – гистограмма с метками
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	// counts[i] – количество значений, не больших buckets[i] (без накопления)
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram создаёт и регистрирует гистограмму. buckets – верхние границы интервалов в порядке возрастания
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets,
+		series: make(map[string]*histogramSeries)}
+	register(h)
+	return h
+}
+
+// Observe добавляет значение v
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			writeValue(w, h.name+"_bucket", addLabel(key, "le", formatFloat(bound)), float64(cumulative))
+		}
+		writeValue(w, h.name+"_bucket", addLabel(key, "le", "+Inf"), float64(s.count))
+		writeValue(w, h.name+"_sum", key, s.sum)
+		writeValue(w, h.name+"_count", key, float64(s.count))
+	}
+}
+
+// funcMetric – метрика, значения которой вычисляются при каждом запросе
+type funcMetric struct {
+	name  string
+	help  string
+	typ   string
+	label string
+	f     func() map[string]float64
+}
+
+// NewGaugeFunc регистрирует метрику, значение которой возвращает f
+func NewGaugeFunc(name, help string, f func() float64) {
+	register(funcMetric{name: name, help: help, typ: "gauge",
+		f: func() map[string]float64 { return map[string]float64{"": f()} }})
+}
+
+// NewCounterFunc регистрирует счётчик, значение которого возвращает f
+func NewCounterFunc(name, help string, f func() float64) {
+	register(funcMetric{name: name, help: help, typ: "counter",
+		f: func() map[string]float64 { return map[string]float64{"": f()} }})
+}
+
+// NewGaugeVecFunc регистрирует метрику с одной меткой. f возвращает значения по значениям метки
+func NewGaugeVecFunc(name, help, label string, f func() map[string]float64) {
+	register(funcMetric{name: name, help: help, typ: "gauge", label: label, f: f})
+}
+
+func (m funcMetric) write(w io.Writer) {
+	values := m.f()
+	if values == nil {
+		return
+	}
+
+	writeHeader(w, m.name, m.help, m.typ)
+	labeled := make(map[string]float64, len(values))
+	for labelValue, v := range values {
+		key := ""
+		if m.label != "" {
+			key = formatLabels([]string{m.label}, []string{labelValue})
+		}
+		labeled[key] = v
+	}
+	for _, key := range sortedKeys(labeled) {
+		writeValue(w, m.name, key, labeled[key])
+	}
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeValue(w io.Writer, name, labels string, v float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(v))
+}
+
+// formatLabels возвращает метки в виде {name="value",...}. Недостающие значения считаются пустыми
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + escapeLabel(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// addLabel добавляет метку к уже отформатированным меткам
+func addLabel(labels, name, value string) string {
+	pair := name + `="` + escapeLabel(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return strings.TrimSuffix(labels, "}") + "," + pair + "}"
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
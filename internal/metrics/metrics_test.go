@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	requests := NewCounter("test_requests_total", "Requests.", "method", "path")
+	requests.Inc("GET", "/")
+	requests.Add(2, "GET", "/")
+	requests.Inc("POST", `C:\path "quoted"`+"\nnext line")
+	requests.Inc("GET") // Недостающее значение метки – пустая строка
+
+	NewCounter("test_unused_total", "Counter without values.")
+
+	duration := NewHistogram("test_duration_seconds", "Duration.", []float64{0.1, 1, 2.5}, "source")
+	duration.Observe(0.25, "ru")
+	duration.Observe(0.5, "ru")
+	duration.Observe(4, "ru")
+	duration.Observe(0.1, "en")
+
+	NewGaugeFunc("test_queue_length", "Queue length.", func() float64 { return 3 })
+	NewCounterFunc("test_gaps_total", "Gaps.", func() float64 { return math.Inf(1) })
+	NewGaugeVecFunc("test_users", "Users.", "state", func() map[string]float64 {
+		return map[string]float64{"stopped": 2, "active": 10}
+	})
+	NewGaugeVecFunc("test_skipped", "Skipped when f returns nil.", "state", func() map[string]float64 {
+		return nil
+	})
+
+	const want = `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{method="GET",path=""} 1
+test_requests_total{method="GET",path="/"} 3
+test_requests_total{method="POST",path="C:\\path \"quoted\"\nnext line"} 1
+# HELP test_unused_total Counter without values.
+# TYPE test_unused_total counter
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{source="en",le="0.1"} 1
+test_duration_seconds_bucket{source="en",le="1"} 1
+test_duration_seconds_bucket{source="en",le="2.5"} 1
+test_duration_seconds_bucket{source="en",le="+Inf"} 1
+test_duration_seconds_sum{source="en"} 0.1
+test_duration_seconds_count{source="en"} 1
+test_duration_seconds_bucket{source="ru",le="0.1"} 0
+test_duration_seconds_bucket{source="ru",le="1"} 2
+test_duration_seconds_bucket{source="ru",le="2.5"} 2
+test_duration_seconds_bucket{source="ru",le="+Inf"} 3
+test_duration_seconds_sum{source="ru"} 4.75
+test_duration_seconds_count{source="ru"} 3
+# HELP test_queue_length Queue length.
+# TYPE test_queue_length gauge
+test_queue_length 3
+# HELP test_gaps_total Gaps.
+# TYPE test_gaps_total counter
+test_gaps_total +Inf
+# HELP test_users Users.
+# TYPE test_users gauge
+test_users{state="active"} 10
+test_users{state="stopped"} 2
+`
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got := rec.Body.String(); got != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type: got %q", ct)
+	}
+}
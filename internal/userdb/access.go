@@ -0,0 +1,230 @@
+package userdb
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+*	Структура бакетов доступа к боту
+*
+*	"access" – пользователи, которым разрешён или запрещён доступ, и ожидающие решения администратора
+*		| id -> Access (json)
+*
+*	"invites" – коды приглашений (/start <код>)
+*		| код -> Invite (json)
+*
+ */
+
+// Статусы доступа
+const (
+	AccessAllowed = "allowed"
+	AccessDenied  = "denied"
+	AccessPending = "pending"
+)
+
+// Access – доступ пользователя к боту
+type Access struct {
+	ID       string    `json:"-"`
+	Status   string    `json:"status"`
+	Username string    `json:"username"`
+	Updated  time.Time `json:"updated"`
+	// id администратора, изменившего доступ (0 – ids.json)
+	By int64 `json:"by"`
+	// код приглашения, по которому пользователь получил доступ
+	Invite string `json:"invite,omitempty"`
+}
+
+// Invite – код приглашения
+type Invite struct {
+	CreatedBy int64     `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// сколько раз ещё можно использовать код
+	Uses int `json:"uses"`
+}
+
+// GetAccess возвращает доступ пользователя. ok == false, если записи нет
+func GetAccess(id string) (access Access, ok bool, err error) {
+	err = dbAdapter.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket([]byte("access")).Get([]byte(id))
+		if raw == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(raw, &access)
+	})
+	access.ID = id
+	return access, ok, err
+}
+
+// SetAccess устанавливает статус доступа пользователя. Пустой username не перезаписывает сохранённый
+func SetAccess(id string, status string, username string, by int64) error {
+	return dbAdapter.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("access"))
+
+		var access Access
+		if raw := bucket.Get([]byte(id)); raw != nil {
+			if err := json.Unmarshal(raw, &access); err != nil {
+				return err
+			}
+		}
+
+		access.Status = status
+		if username != "" {
+			access.Username = username
+		}
+		access.Updated = time.Now()
+		access.By = by
+		return putAccess(bucket, id, access)
+	})
+}
+
+// RequestAccess создаёт запрос на доступ. Если у пользователя уже есть запись (запрос, разрешение или запрет),
+// ничего не изменяется, а ok == false
+func RequestAccess(id string, username string) (ok bool, err error) {
+	err = dbAdapter.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("access"))
+		if bucket.Get([]byte(id)) != nil {
+			return nil
+		}
+
+		ok = true
+		return putAccess(bucket, id, Access{Status: AccessPending, Username: username, Updated: time.Now()})
+	})
+	return ok, err
+}
+
+// AllowUsers разрешает доступ пользователям, у которых ещё нет записи или запрос ожидает решения (используется для ids.json).
+// Запрет, установленный администратором, не перезаписывается. Возвращает id, которым доступ был разрешён
+func AllowUsers(ids []string) ([]string, error) {
+	var added []string
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("access"))
+		for _, id := range ids {
+			var access Access
+			if raw := bucket.Get([]byte(id)); raw != nil {
+				if err := json.Unmarshal(raw, &access); err != nil {
+					return err
+				}
+				if access.Status != AccessPending {
+					continue
+				}
+			}
+
+			access.Status = AccessAllowed
+			access.Updated = time.Now()
+			if err := putAccess(bucket, id, access); err != nil {
+				return err
+			}
+			added = append(added, id)
+		}
+		return nil
+	})
+	return added, err
+}
+
+// GetAccessList возвращает пользователей со статусом status (сначала последние изменённые)
+func GetAccessList(status string) ([]Access, error) {
+	list := []Access{}
+	err := dbAdapter.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("access")).ForEach(func(k, v []byte) error {
+			var access Access
+			if err := json.Unmarshal(v, &access); err != nil {
+				return err
+			}
+			if access.Status == status {
+				access.ID = string(k)
+				list = append(list, access)
+			}
+			return nil
+		})
+	})
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Updated.After(list[j].Updated)
+	})
+	return list, err
+}
+
+// CreateInvite создаёт код приглашения, который можно использовать uses раз
+func CreateInvite(by int64, uses int) (string, error) {
+	buf := make([]byte, 9)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(buf)
+
+	raw, err := json.Marshal(Invite{CreatedBy: by, CreatedAt: time.Now(), Uses: uses})
+	if err != nil {
+		return "", err
+	}
+
+	err = dbAdapter.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("invites")).Put([]byte(code), raw)
+	})
+	return code, err
+}
+
+// UseInvite разрешает доступ пользователю по коду приглашения. ok == false, если код недействителен
+// или пользователю запрещён доступ. Использованный нужное количество раз код удаляется.
+// Если доступ уже разрешён, ни код, ни запись доступа не изменяются
+func UseInvite(code string, id string, username string) (ok bool, invite Invite, err error) {
+	err = dbAdapter.Update(func(tx *bolt.Tx) error {
+		invitesBucket := tx.Bucket([]byte("invites"))
+		raw := invitesBucket.Get([]byte(code))
+		if raw == nil {
+			return nil
+		}
+		if err := json.Unmarshal(raw, &invite); err != nil {
+			return err
+		}
+
+		accessBucket := tx.Bucket([]byte("access"))
+		var access Access
+		if raw := accessBucket.Get([]byte(id)); raw != nil {
+			if err := json.Unmarshal(raw, &access); err != nil {
+				return err
+			}
+			switch access.Status {
+			case AccessDenied:
+				return nil
+			case AccessAllowed:
+				ok = true
+				return nil
+			}
+		}
+
+		invite.Uses--
+		if invite.Uses <= 0 {
+			if err := invitesBucket.Delete([]byte(code)); err != nil {
+				return err
+			}
+		} else {
+			raw, err := json.Marshal(invite)
+			if err != nil {
+				return err
+			}
+			if err := invitesBucket.Put([]byte(code), raw); err != nil {
+				return err
+			}
+		}
+
+		ok = true
+		access = Access{Status: AccessAllowed, Username: username, Updated: time.Now(), By: invite.CreatedBy, Invite: code}
+		return putAccess(accessBucket, id, access)
+	})
+	return ok, invite, err
+}
+
+func putAccess(bucket *bolt.Bucket, id string, access Access) error {
+	raw, err := json.Marshal(access)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(id), raw)
+}
@@ -0,0 +1,55 @@
+package userdb
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestUseInvite(t *testing.T) {
+	if err := Open(filepath.Join(t.TempDir(), "users.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+
+	code, err := CreateInvite(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetAccess("100", AccessAllowed, "old", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetAccess("200", AccessDenied, "", 7); err != nil {
+		t.Fatal(err)
+	}
+
+	// Пользователь с разрешённым доступом не тратит приглашение, его запись доступа не меняется
+	ok, invite, err := UseInvite(code, "100", "new")
+	if err != nil || !ok || invite.Uses != 2 {
+		t.Errorf("allowed user: got ok %v, uses %d, err %v", ok, invite.Uses, err)
+	}
+	access, _, _ := GetAccess("100")
+	if access.By != 7 || access.Invite != "" || access.Username != "old" {
+		t.Errorf("access of the allowed user must not change: %+v", access)
+	}
+
+	// Пользователю с запрещённым доступом приглашение не помогает
+	if ok, _, err := UseInvite(code, "200", ""); err != nil || ok {
+		t.Errorf("denied user: got ok %v, err %v", ok, err)
+	}
+
+	for i, id := range []string{"300", "400"} {
+		ok, invite, err := UseInvite(code, id, "")
+		if err != nil || !ok || invite.Uses != 1-i {
+			t.Errorf("user %s: got ok %v, uses %d, err %v", id, ok, invite.Uses, err)
+		}
+		access, _, _ := GetAccess(id)
+		if access.Status != AccessAllowed || access.By != 1 || access.Invite != code {
+			t.Errorf("user %s: unexpected access %+v", id, access)
+		}
+	}
+
+	// Приглашение использовано нужное количество раз и удалено
+	if ok, _, err := UseInvite(code, "500", ""); err != nil || ok {
+		t.Errorf("used invite: got ok %v, err %v", ok, err)
+	}
+}
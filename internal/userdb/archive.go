@@ -0,0 +1,202 @@
+package userdb
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+*	Структура бакетов с архивом статей
+*
+*	"archive"
+*		| ключ статьи (номер поста Habr или ссылка) -> ArchivedArticle (json)
+*
+*	"archive_index" – инвертированный индекс для поиска
+*		|-> слово (основа слова)
+*			| ключ статьи -> вес слова в статье
+*
+ */
+
+// ArchivedArticle – статья, прошедшая через бота
+type ArchivedArticle struct {
+	PostID      string    `json:"-"`
+	Title       string    `json:"title"`
+	Link        string    `json:"link"`
+	Tags        []string  `json:"tags"`
+	Author      string    `json:"author"`
+	Description string    `json:"description"`
+	Published   time.Time `json:"published"`
+	AddedAt     time.Time `json:"added_at"`
+	// слова статьи и их веса. Нужны, чтобы удалить статью из индекса
+	Terms map[string]int `json:"terms"`
+}
+
+// SearchResult – статья, найденная в архиве
+type SearchResult struct {
+	PostID string
+	// количество найденных слов запроса
+	Matched int
+	// сумма весов найденных слов
+	Score int
+}
+
+// PutArchived сохраняет статью в архив (перезаписывая старую запись) и индексирует её слова
+func PutArchived(article ArchivedArticle) error {
+	raw, err := json.Marshal(article)
+	if err != nil {
+		return err
+	}
+
+	return dbAdapter.Update(func(tx *bolt.Tx) error {
+		archiveBucket := tx.Bucket([]byte("archive"))
+		indexBucket := tx.Bucket([]byte("archive_index"))
+		key := []byte(article.PostID)
+
+		// Удаление старой записи из индекса
+		if old := archiveBucket.Get(key); old != nil {
+			var oldArticle ArchivedArticle
+			if err := json.Unmarshal(old, &oldArticle); err == nil {
+				if err := unindex(indexBucket, key, oldArticle.Terms); err != nil {
+					return err
+				}
+			}
+		}
+
+		for term, weight := range article.Terms {
+			termBucket, err := indexBucket.CreateBucketIfNotExists([]byte(term))
+			if err != nil {
+				return err
+			}
+			if err := termBucket.Put(key, []byte(strconv.Itoa(weight))); err != nil {
+				return err
+			}
+		}
+
+		return archiveBucket.Put(key, raw)
+	})
+}
+
+// unindex удаляет статью key из индекса
+func unindex(indexBucket *bolt.Bucket, key []byte, terms map[string]int) error {
+	for term := range terms {
+		termBucket := indexBucket.Bucket([]byte(term))
+		if termBucket == nil {
+			continue
+		}
+		if err := termBucket.Delete(key); err != nil {
+			return err
+		}
+		// Пустые бакеты слов удаляются
+		if k, _ := termBucket.Cursor().First(); k == nil {
+			if err := indexBucket.DeleteBucket([]byte(term)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetArchived возвращает статью из архива. Второе возвращаемое значение – false, если статьи нет
+func GetArchived(postID string) (ArchivedArticle, bool, error) {
+	var article ArchivedArticle
+	var ok bool
+
+	err := dbAdapter.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket([]byte("archive")).Get([]byte(postID))
+		if raw == nil {
+			return nil
+		}
+
+		ok = true
+		return json.Unmarshal(raw, &article)
+	})
+	if err != nil {
+		return ArchivedArticle{}, false, err
+	}
+
+	article.PostID = postID
+	return article, ok, nil
+}
+
+// SearchArchive возвращает статьи, содержащие хотя бы одно из слов terms (в произвольном порядке)
+func SearchArchive(terms []string) ([]SearchResult, error) {
+	results := make(map[string]*SearchResult)
+
+	err := dbAdapter.View(func(tx *bolt.Tx) error {
+		indexBucket := tx.Bucket([]byte("archive_index"))
+		for _, term := range terms {
+			termBucket := indexBucket.Bucket([]byte(term))
+			if termBucket == nil {
+				continue
+			}
+
+			err := termBucket.ForEach(func(k, v []byte) error {
+				weight, _ := strconv.Atoi(string(v))
+				r, ok := results[string(k)]
+				if !ok {
+					r = &SearchResult{PostID: string(k)}
+					results[string(k)] = r
+				}
+				r.Matched++
+				r.Score += weight
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return []SearchResult{}, err
+	}
+
+	list := make([]SearchResult, 0, len(results))
+	for _, r := range results {
+		list = append(list, *r)
+	}
+	return list, nil
+}
+
+// CleanArchive удаляет статьи, добавленные в архив раньше, чем period назад
+// Возвращает количество удалённых статей
+func CleanArchive(period time.Duration) (int, error) {
+	var counter int
+	deadline := time.Now().Add(-period)
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		archiveBucket := tx.Bucket([]byte("archive"))
+		indexBucket := tx.Bucket([]byte("archive_index"))
+
+		old := make(map[string]ArchivedArticle)
+		err := archiveBucket.ForEach(func(k, v []byte) error {
+			var article ArchivedArticle
+			if err := json.Unmarshal(v, &article); err != nil || article.AddedAt.Before(deadline) {
+				old[string(k)] = article
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for key, article := range old {
+			if err := unindex(indexBucket, []byte(key), article.Terms); err != nil {
+				return err
+			}
+			if err := archiveBucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+			counter++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return counter, nil
+}
@@ -0,0 +1,135 @@
+package userdb
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+*	Структура бакета с закладками
+*
+*	"bookmarks"
+*		|-> id
+*			| номер поста Habr или хеш ссылки ("h…") -> Bookmark (json)
+*
+ */
+
+// Bookmark – статья, сохранённая пользователем
+type Bookmark struct {
+	PostID  string    `json:"-"`
+	Title   string    `json:"title"`
+	Link    string    `json:"link"`
+	Tags    []string  `json:"tags"`
+	SavedAt time.Time `json:"saved_at"`
+	// true, если пользователю уже напоминали о закладке
+	Reminded bool `json:"reminded"`
+}
+
+// AddBookmark сохраняет статью в закладки пользователя.
+// Возвращает false, если статья уже есть в закладках
+func AddBookmark(id string, bookmark Bookmark) (bool, error) {
+	raw, err := json.Marshal(bookmark)
+	if err != nil {
+		return false, err
+	}
+
+	var added bool
+	err = dbAdapter.Update(func(tx *bolt.Tx) error {
+		userBucket, err := tx.Bucket([]byte("bookmarks")).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+
+		if userBucket.Get([]byte(bookmark.PostID)) != nil {
+			return nil
+		}
+
+		added = true
+		return userBucket.Put([]byte(bookmark.PostID), raw)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return added, nil
+}
+
+// GetBookmarks возвращает закладки пользователя (новые раньше)
+func GetBookmarks(id string) ([]Bookmark, error) {
+	bookmarks := []Bookmark{}
+
+	err := dbAdapter.View(func(tx *bolt.Tx) error {
+		userBucket := tx.Bucket([]byte("bookmarks")).Bucket([]byte(id))
+		if userBucket == nil {
+			return nil
+		}
+
+		return userBucket.ForEach(func(k, v []byte) error {
+			var bookmark Bookmark
+			if err := json.Unmarshal(v, &bookmark); err != nil {
+				return nil
+			}
+			bookmark.PostID = string(k)
+			bookmarks = append(bookmarks, bookmark)
+			return nil
+		})
+	})
+	if err != nil {
+		return []Bookmark{}, err
+	}
+
+	sort.SliceStable(bookmarks, func(i, j int) bool {
+		return bookmarks[i].SavedAt.After(bookmarks[j].SavedAt)
+	})
+
+	return bookmarks, nil
+}
+
+// DelBookmark удаляет статью из закладок пользователя. Возвращает false, если такой закладки нет
+func DelBookmark(id string, postID string) (bool, error) {
+	var deleted bool
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		userBucket := tx.Bucket([]byte("bookmarks")).Bucket([]byte(id))
+		if userBucket == nil || userBucket.Get([]byte(postID)) == nil {
+			return nil
+		}
+
+		deleted = true
+		return userBucket.Delete([]byte(postID))
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return deleted, nil
+}
+
+// MarkBookmarksReminded отмечает, что пользователю напомнили о закладках
+func MarkBookmarksReminded(id string, postIDs []string) error {
+	return dbAdapter.Update(func(tx *bolt.Tx) error {
+		userBucket := tx.Bucket([]byte("bookmarks")).Bucket([]byte(id))
+		if userBucket == nil {
+			return nil
+		}
+
+		for _, postID := range postIDs {
+			var bookmark Bookmark
+			if err := json.Unmarshal(userBucket.Get([]byte(postID)), &bookmark); err != nil {
+				continue
+			}
+			bookmark.Reminded = true
+
+			raw, err := json.Marshal(bookmark)
+			if err != nil {
+				return err
+			}
+			if err := userBucket.Put([]byte(postID), raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -1,9 +1,11 @@
 package userdb
 
 import (
+	"encoding/json"
 	"errors"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func toSlice(data []byte) ([]string, error) {
@@ -29,6 +31,27 @@ func toSlice(data []byte) ([]string, error) {
 	return result, nil
 }
 
+// toOptionalSlice работает как toSlice, но для несуществующего поля возвращает пустой slice
+func toOptionalSlice(data []byte) []string {
+	if data == nil {
+		return []string{}
+	}
+
+	result, _ := toSlice(data)
+	return result
+}
+
+// toJSONSlice декодирует slice, хранящийся в json. Для несуществующего или некорректного поля возвращает пустой slice
+func toJSONSlice(data []byte) []string {
+	result := []string{}
+	if data == nil {
+		return result
+	}
+
+	json.Unmarshal(data, &result)
+	return result
+}
+
 func toBool(data []byte) (bool, error) {
 	if data == nil {
 		return false, errors.New("Field doesn't exist")
@@ -45,6 +68,15 @@ func toInt64(data []byte) (int64, error) {
 	return strconv.ParseInt(string(data), 10, 64)
 }
 
+// toOptionalTime преобразует unix-время в time.Time. Для несуществующего или некорректного поля возвращает нулевое время
+func toOptionalTime(data []byte) time.Time {
+	sec, err := toInt64(data)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
 func addTags(oldTags, newTags []string) []string {
 	oldTagsMap := make(map[string]int)
 	for _, tag := range oldTags {
@@ -0,0 +1,181 @@
+package userdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+*	Структура бакетов с исходящими сообщениями
+*
+*	"outbox" – сообщения, которые ещё не были отправлены
+*		| номер -> OutboxItem (json)
+*
+*	"outbox_keys" – ключи уже поставленных в очередь сообщений (для защиты от повторной отправки)
+*		| "id:ключ" -> время постановки в очередь (unix)
+*
+ */
+
+// OutboxItem – сообщение, ожидающее отправки
+type OutboxItem struct {
+	ID     uint64 `json:"-"`
+	ChatID int64  `json:"chat_id"`
+	// ключ для защиты от повторной отправки (например, ссылка на статью). Может быть пустым
+	Key string `json:"key"`
+	// сообщение в сериализованном виде
+	Message json.RawMessage `json:"message"`
+}
+
+// EnqueueOutbox сохраняет сообщение в очереди на отправку.
+// Если сообщение с таким же ключом уже ставилось в очередь для этого пользователя, оно не сохраняется, а ok == false
+func EnqueueOutbox(chatID int64, key string, message []byte) (id uint64, ok bool, err error) {
+	item := OutboxItem{ChatID: chatID, Key: key, Message: message}
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = dbAdapter.Update(func(tx *bolt.Tx) error {
+		if key != "" {
+			keysBucket := tx.Bucket([]byte("outbox_keys"))
+			fullKey := []byte(strconv.FormatInt(chatID, 10) + ":" + key)
+			if keysBucket.Get(fullKey) != nil {
+				return nil
+			}
+			err := keysBucket.Put(fullKey, []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+			if err != nil {
+				return err
+			}
+		}
+
+		outboxBucket := tx.Bucket([]byte("outbox"))
+		seq, err := outboxBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		id, ok = seq, true
+		return outboxBucket.Put(itob(seq), raw)
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	return id, ok, nil
+}
+
+// CompleteOutbox удаляет сообщение из очереди на отправку
+func CompleteOutbox(id uint64) error {
+	return dbAdapter.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("outbox")).Delete(itob(id))
+	})
+}
+
+// GetOutbox возвращает все неотправленные сообщения в порядке постановки в очередь
+func GetOutbox() ([]OutboxItem, error) {
+	items := []OutboxItem{}
+
+	err := dbAdapter.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("outbox")).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var item OutboxItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				continue
+			}
+			item.ID = binary.BigEndian.Uint64(k)
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return []OutboxItem{}, err
+	}
+
+	return items, nil
+}
+
+// CleanOutboxKeys удаляет ключи сообщений, поставленных в очередь раньше, чем period назад
+// Возвращает количество удалённых ключей
+func CleanOutboxKeys(period time.Duration) (int, error) {
+	var counter int
+	deadline := time.Now().Add(-period)
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		keysBucket := tx.Bucket([]byte("outbox_keys"))
+
+		var keys [][]byte
+		c := keysBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if toOptionalTime(v).Before(deadline) {
+				keys = append(keys, k)
+			}
+		}
+
+		for _, k := range keys {
+			if err := keysBucket.Delete(k); err != nil {
+				return err
+			}
+			counter++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return counter, nil
+}
+
+// DeleteUserQueue удаляет все неотправленные сообщения пользователя, а также его статьи для дайджеста
+// и статьи, отложенные на время тихих часов. Возвращает количество удалённых сообщений и статей
+func DeleteUserQueue(id string) (int, error) {
+	chatID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	var counter int
+	err = dbAdapter.Update(func(tx *bolt.Tx) error {
+		outboxBucket := tx.Bucket([]byte("outbox"))
+
+		var keys [][]byte
+		c := outboxBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var item OutboxItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				continue
+			}
+			if item.ChatID == chatID {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			if err := outboxBucket.Delete(k); err != nil {
+				return err
+			}
+			counter++
+		}
+
+		for _, name := range []string{"pending", "held"} {
+			bucket := tx.Bucket([]byte(name))
+			userBucket := bucket.Bucket([]byte(id))
+			if userBucket == nil {
+				continue
+			}
+			counter += userBucket.Stats().KeyN
+			if err := bucket.DeleteBucket([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return counter, nil
+}
@@ -0,0 +1,171 @@
+package userdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+*	Структура бакетов со статьями, ожидающими отправки
+*
+*	"pending" – статьи для дайджеста
+*	"held" – статьи, пришедшие во время тихих часов
+*		|-> id
+*			| номер -> PendingArticle (json)
+*
+ */
+
+// Режимы доставки статей
+const (
+	DeliveryInstant = "instant"
+	DeliveryHourly  = "hourly"
+	DeliveryDaily   = "daily"
+	DeliveryWeekly  = "weekly"
+)
+
+// PendingArticle – статья, ожидающая отправки
+type PendingArticle struct {
+	Title string `json:"title"`
+	Link  string `json:"link"`
+}
+
+// itob преобразует число в ключ (big endian, чтобы ключи были отсортированы по порядку добавления)
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// SetDelivery устанавливает режим доставки статей
+func SetDelivery(id string, mode string) error {
+	switch mode {
+	case DeliveryInstant, DeliveryHourly, DeliveryDaily, DeliveryWeekly:
+	default:
+		return errors.New("unknown delivery mode '" + mode + "'")
+	}
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		return userBucket.Put([]byte("Delivery"), []byte(mode))
+	})
+
+	return err
+}
+
+// SetDigestSentAt сохраняет время последней отправки дайджеста
+func SetDigestSentAt(id string, t time.Time) error {
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		return userBucket.Put([]byte("DigestSentAt"), []byte(strconv.FormatInt(t.Unix(), 10)))
+	})
+
+	return err
+}
+
+// AddPendingArticle добавляет статью в дайджест пользователя
+func AddPendingArticle(id string, article PendingArticle) error {
+	return addArticle("pending", id, article)
+}
+
+// TakePendingArticles возвращает статьи из дайджеста пользователя (в порядке добавления) и очищает дайджест
+func TakePendingArticles(id string) ([]PendingArticle, error) {
+	return takeArticles("pending", id)
+}
+
+// AddHeldArticle откладывает статью до окончания тихих часов пользователя
+func AddHeldArticle(id string, article PendingArticle) error {
+	return addArticle("held", id, article)
+}
+
+// TakeHeldArticles возвращает статьи, отложенные на время тихих часов (в порядке добавления), и удаляет их
+func TakeHeldArticles(id string) ([]PendingArticle, error) {
+	return takeArticles("held", id)
+}
+
+// addArticle добавляет статью в бакет bucket пользователя
+func addArticle(bucket string, id string, article PendingArticle) error {
+	raw, err := json.Marshal(article)
+	if err != nil {
+		return err
+	}
+
+	err = dbAdapter.Update(func(tx *bolt.Tx) error {
+		userBucket, err := tx.Bucket([]byte(bucket)).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+
+		seq, err := userBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return userBucket.Put(itob(seq), raw)
+	})
+
+	return err
+}
+
+// takeArticles возвращает статьи из бакета bucket пользователя (в порядке добавления) и удаляет их
+func takeArticles(bucket string, id string) ([]PendingArticle, error) {
+	articles := []PendingArticle{}
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		pendingBucket := tx.Bucket([]byte(bucket))
+
+		userBucket := pendingBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return nil
+		}
+
+		c := userBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var article PendingArticle
+			if err := json.Unmarshal(v, &article); err != nil {
+				continue
+			}
+			articles = append(articles, article)
+		}
+
+		return pendingBucket.DeleteBucket([]byte(id))
+	})
+	if err != nil {
+		return []PendingArticle{}, err
+	}
+
+	return articles, nil
+}
+
+// GetHeldArticlesOwners возвращает id пользователей, у которых есть отложенные на время тихих часов статьи
+func GetHeldArticlesOwners() ([]string, error) {
+	ids := []string{}
+
+	err := dbAdapter.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("held")).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			ids = append(ids, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,125 @@
+package userdb
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+*	Структура бакета с уже обработанными статьями
+*
+*	"seen"
+*		|-> имя источника
+*			| ключ статьи (номер поста Habr или ссылка) -> время последнего появления в ленте (unix)
+*
+ */
+
+// GetSeen возвращает ключи из keys, которые уже были обработаны для источника source.
+// Второе возвращаемое значение – false, если у источника ещё нет обработанных статей
+func GetSeen(source string, keys []string) (map[string]bool, bool, error) {
+	seen := make(map[string]bool)
+	var existed bool
+
+	err := dbAdapter.View(func(tx *bolt.Tx) error {
+		sourceBucket := tx.Bucket([]byte("seen")).Bucket([]byte(source))
+		if sourceBucket == nil {
+			return nil
+		}
+
+		existed = true
+		for _, key := range keys {
+			if sourceBucket.Get([]byte(key)) != nil {
+				seen[key] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return map[string]bool{}, false, err
+	}
+
+	return seen, existed, nil
+}
+
+// MarkSeen помечает статьи источника source обработанными в момент t.
+// Бакет источника создаётся, даже если keys пустой
+func MarkSeen(source string, keys []string, t time.Time) error {
+	value := []byte(strconv.FormatInt(t.Unix(), 10))
+
+	return dbAdapter.Update(func(tx *bolt.Tx) error {
+		sourceBucket, err := tx.Bucket([]byte("seen")).CreateBucketIfNotExists([]byte(source))
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := sourceBucket.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// HasSeen проверяет, есть ли в базе данных хотя бы один источник с обработанными статьями
+func HasSeen() (bool, error) {
+	var has bool
+	err := dbAdapter.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket([]byte("seen")).Cursor().First()
+		has = k != nil
+		return nil
+	})
+	return has, err
+}
+
+// DeleteSeenSource удаляет обработанные статьи источника source
+func DeleteSeenSource(source string) error {
+	return dbAdapter.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte("seen")).DeleteBucket([]byte(source))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// CleanSeen удаляет статьи, которые последний раз появлялись в ленте раньше, чем period назад
+// Возвращает количество удалённых статей
+func CleanSeen(period time.Duration) (int, error) {
+	var counter int
+	deadline := time.Now().Add(-period)
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		seenBucket := tx.Bucket([]byte("seen"))
+
+		return seenBucket.ForEach(func(source, _ []byte) error {
+			sourceBucket := seenBucket.Bucket(source)
+			if sourceBucket == nil {
+				return nil
+			}
+
+			var keys [][]byte
+			c := sourceBucket.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if toOptionalTime(v).Before(deadline) {
+					keys = append(keys, k)
+				}
+			}
+
+			for _, k := range keys {
+				if err := sourceBucket.Delete(k); err != nil {
+					return err
+				}
+				counter++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return counter, nil
+}
@@ -0,0 +1,125 @@
+package userdb
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// SetTimezone устанавливает часовой пояс пользователя (название из базы IANA)
+func SetTimezone(id string, timezone string) error {
+	return setUserField(id, "Timezone", timezone)
+}
+
+// SetQuietHours устанавливает тихие часы ("23:00-08:00", пустая строка – выключены) и режим ("hold" или "silent")
+func SetQuietHours(id string, quiet string, mode string) error {
+	err := setUserField(id, "Quiet", quiet)
+	if err != nil {
+		return err
+	}
+	return setUserField(id, "QuietMode", mode)
+}
+
+// SetBestSentAt сохраняет время последней отправки лучших статей
+func SetBestSentAt(id string, t time.Time) error {
+	return setUserField(id, "BestSentAt", strconv.FormatInt(t.Unix(), 10))
+}
+
+// SetRemindAfter устанавливает, через сколько дней напоминать о закладках (0 – не напоминать)
+func SetRemindAfter(id string, days int) error {
+	return setUserField(id, "RemindAfter", strconv.Itoa(days))
+}
+
+// SetBest устанавливает параметры ежедневной рассылки лучших статей
+func SetBest(id string, best string) error {
+	return setUserField(id, "Best", best)
+}
+
+// setUserField записывает значение поля пользователя
+func setUserField(id string, field string, value string) error {
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		return userBucket.Put([]byte(field), []byte(value))
+	})
+
+	return err
+}
+
+// DeactivateUser помечает пользователя неактивным (например, если он заблокировал бота), сохраняя время и причину
+func DeactivateUser(id string, reason string) error {
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		// Время первой деактивации не перезаписывается
+		if active := userBucket.Get([]byte("Active")); active != nil && string(active) == "false" {
+			return nil
+		}
+
+		userBucket.Put([]byte("Active"), []byte("false"))
+		userBucket.Put([]byte("DeactivatedAt"), []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+		return userBucket.Put([]byte("DeactivationReason"), []byte(reason))
+	})
+
+	return err
+}
+
+// PurgeInactiveUsers удаляет пользователей, которые неактивны дольше, чем period
+// Возвращает количество удалённых пользователей
+func PurgeInactiveUsers(period time.Duration) (int, error) {
+	var counter int
+	deadline := time.Now().Add(-period)
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		var ids [][]byte
+		c := usersBucket.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			userBucket := usersBucket.Bucket(k)
+			if userBucket == nil {
+				continue
+			}
+
+			if string(userBucket.Get([]byte("Active"))) != "false" {
+				continue
+			}
+			deactivatedAt := toOptionalTime(userBucket.Get([]byte("DeactivatedAt")))
+			if deactivatedAt.Before(deadline) {
+				ids = append(ids, k)
+			}
+		}
+
+		// Удаление во время обхода курсором не поддерживается, поэтому пользователи удаляются после
+		for _, id := range ids {
+			if err := usersBucket.DeleteBucket(id); err != nil {
+				return err
+			}
+			for _, name := range []string{"pending", "held", "bookmarks"} {
+				if tx.Bucket([]byte(name)).Bucket(id) != nil {
+					tx.Bucket([]byte(name)).DeleteBucket(id)
+				}
+			}
+			counter++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return counter, nil
+}
@@ -1,8 +1,11 @@
 package userdb
 
 import (
+	"encoding/json"
 	"errors"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
@@ -14,16 +17,89 @@ import (
 *		|-> id
 *			| Tags
 *			| Mailout
+*			| Feeds
+*			| Filters (json)
+*			| Keywords
+*			| Regexps (json)
+*			| FollowedAuthors
+*			| BlockedAuthors
+*			| FollowedCompanies
+*			| BlockedCompanies
+*			| Delivery
+*			| DigestSentAt
+*			| Timezone
+*			| Quiet
+*			| QuietMode
+*			| BestSentAt
+*			| Best
+*			| Active
+*			| DeactivatedAt
+*			| DeactivationReason
+*			| RemindAfter
 *
  */
 
 // User содержит в себе информацию о пользователе
 type User struct {
-	ID      int64    `json:"id"`
-	Tags    []string `json:"tags"`
-	Mailout bool     `json:"mailout"`
+	ID       int64    `json:"id"`
+	Tags     []string `json:"tags"`
+	Mailout  bool     `json:"mailout"`
+	Feeds    []string `json:"feeds"`
+	Filters  []string `json:"filters"`
+	Keywords []string `json:"keywords"`
+	Regexps  []string `json:"regexps"`
+
+	FollowedAuthors   []string `json:"followed_authors"`
+	BlockedAuthors    []string `json:"blocked_authors"`
+	FollowedCompanies []string `json:"followed_companies"`
+	BlockedCompanies  []string `json:"blocked_companies"`
+
+	// режим доставки статей (DeliveryInstant, DeliveryHourly, ...)
+	Delivery     string    `json:"delivery"`
+	DigestSentAt time.Time `json:"digest_sent_at"`
+
+	// часовой пояс из базы IANA. Пустая строка – часовой пояс сервера
+	Timezone string `json:"timezone"`
+	// тихие часы в виде "23:00-08:00". Пустая строка – тихие часы выключены
+	Quiet string `json:"quiet"`
+	// что делать со статьями во время тихих часов: QuietHold или QuietSilent
+	QuietMode  string    `json:"quiet_mode"`
+	BestSentAt time.Time `json:"best_sent_at"`
+	// параметры ежедневной рассылки лучших статей (например, "week ru mine 7"). "off" – рассылка выключена,
+	// пустая строка – параметры по-умолчанию
+	Best string `json:"best"`
+
+	// false, если пользователь заблокировал бота или удалил аккаунт (в отличие от Mailout, который выключается командой /stop)
+	Active             bool      `json:"active"`
+	DeactivatedAt      time.Time `json:"deactivated_at"`
+	DeactivationReason string    `json:"deactivation_reason"`
+
+	// через сколько дней напоминать о непрочитанных закладках. 0 – не напоминать
+	RemindAfter int `json:"remind_after"`
 }
 
+// Режимы тихих часов
+const (
+	// статьи откладываются до окончания тихих часов
+	QuietHold = "hold"
+	// статьи присылаются без звука
+	QuietSilent = "silent"
+)
+
+// Поля пользователя, содержащие списки авторов и компаний
+const (
+	FollowedAuthorsField   = "FollowedAuthors"
+	BlockedAuthorsField    = "BlockedAuthors"
+	FollowedCompaniesField = "FollowedCompanies"
+	BlockedCompaniesField  = "BlockedCompanies"
+)
+
+// ErrNoSuchElement возвращается, если элемента с указанным номером нет в списке
+var ErrNoSuchElement = errors.New("element with such number doesn't exist")
+
+// ErrNoSuchUser возвращается GetUser, если пользователя нет в базе данных
+var ErrNoSuchUser = errors.New("user doesn't exist")
+
 var dbAdapter *bolt.DB
 
 // Open открывает базу данных (или создаёт, если не существует)
@@ -35,8 +111,13 @@ func Open(relativePath string) error {
 	}
 
 	err = dbAdapter.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("users"))
-		return err
+		for _, name := range []string{"users", "pending", "held", "outbox", "outbox_keys", "seen", "bookmarks", "archive", "archive_index", "access", "invites"} {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 	if err != nil {
 		return err
@@ -64,9 +145,13 @@ func CreateUser(id string) error {
 			}
 			userBucket.Put([]byte("Tags"), []byte(""))
 			userBucket.Put([]byte("Mailout"), []byte("true"))
+			userBucket.Put([]byte("Active"), []byte("true"))
 		} else {
-			// Если пользователь существовал, то просто включаем ему рассылку
+			// Если пользователь существовал, то просто включаем ему рассылку и активируем его
 			userBucket.Put([]byte("Mailout"), []byte("true"))
+			userBucket.Put([]byte("Active"), []byte("true"))
+			userBucket.Delete([]byte("DeactivatedAt"))
+			userBucket.Delete([]byte("DeactivationReason"))
 		}
 
 		return nil
@@ -85,23 +170,11 @@ func GetUser(id string) (User, error) {
 
 		userBucket := usersBucket.Bucket([]byte(id))
 		if userBucket == nil {
-			return errors.New("User with id '" + id + "' doesn't exist")
-		}
-
-		user.ID, err = toInt64([]byte(id))
-		if err != nil {
-			return err
-		}
-		user.Tags, err = toSlice(userBucket.Get([]byte("Tags")))
-		if err != nil {
-			return err
-		}
-		user.Mailout, err = toBool(userBucket.Get([]byte("Mailout")))
-		if err != nil {
-			return err
+			return ErrNoSuchUser
 		}
 
-		return nil
+		user, err = readUser([]byte(id), userBucket)
+		return err
 	})
 
 	if err != nil {
@@ -111,6 +184,60 @@ func GetUser(id string) (User, error) {
 	return user, nil
 }
 
+// readUser читает данные пользователя из его бакета
+func readUser(id []byte, userBucket *bolt.Bucket) (User, error) {
+	var user User
+	var err error
+
+	user.ID, err = toInt64(id)
+	if err != nil {
+		return User{}, err
+	}
+	user.Tags, err = toSlice(userBucket.Get([]byte("Tags")))
+	if err != nil {
+		return User{}, err
+	}
+	user.Mailout, err = toBool(userBucket.Get([]byte("Mailout")))
+	if err != nil {
+		return User{}, err
+	}
+	// Поле появилось позже, поэтому у старых пользователей его может не быть
+	user.Feeds = toOptionalSlice(userBucket.Get([]byte("Feeds")))
+	// Фильтры содержат пробелы, поэтому хранятся в json
+	user.Filters = toJSONSlice(userBucket.Get([]byte("Filters")))
+	user.Keywords = toOptionalSlice(userBucket.Get([]byte("Keywords")))
+	user.Regexps = toJSONSlice(userBucket.Get([]byte("Regexps")))
+	user.FollowedAuthors = toOptionalSlice(userBucket.Get([]byte(FollowedAuthorsField)))
+	user.BlockedAuthors = toOptionalSlice(userBucket.Get([]byte(BlockedAuthorsField)))
+	user.FollowedCompanies = toOptionalSlice(userBucket.Get([]byte(FollowedCompaniesField)))
+	user.BlockedCompanies = toOptionalSlice(userBucket.Get([]byte(BlockedCompaniesField)))
+	user.Delivery = string(userBucket.Get([]byte("Delivery")))
+	if user.Delivery == "" {
+		user.Delivery = DeliveryInstant
+	}
+	user.DigestSentAt = toOptionalTime(userBucket.Get([]byte("DigestSentAt")))
+	user.Timezone = string(userBucket.Get([]byte("Timezone")))
+	user.Quiet = string(userBucket.Get([]byte("Quiet")))
+	user.QuietMode = string(userBucket.Get([]byte("QuietMode")))
+	if user.QuietMode == "" {
+		user.QuietMode = QuietHold
+	}
+	user.BestSentAt = toOptionalTime(userBucket.Get([]byte("BestSentAt")))
+	user.Best = string(userBucket.Get([]byte("Best")))
+	// У пользователей, созданных до появления поля, его нет – они считаются активными
+	user.Active = true
+	if active := userBucket.Get([]byte("Active")); active != nil {
+		user.Active, _ = toBool(active)
+	}
+	user.DeactivatedAt = toOptionalTime(userBucket.Get([]byte("DeactivatedAt")))
+	user.DeactivationReason = string(userBucket.Get([]byte("DeactivationReason")))
+	if remindAfter, err := toInt64(userBucket.Get([]byte("RemindAfter"))); err == nil {
+		user.RemindAfter = int(remindAfter)
+	}
+
+	return user, nil
+}
+
 // GetAllUsers возвращает slice, содержащий данные о всех пользователях
 func GetAllUsers() ([]User, error) {
 	users := make([]User, 0)
@@ -125,18 +252,7 @@ func GetAllUsers() ([]User, error) {
 		for k, _ := c.First(); k != nil; k, _ = c.Next() {
 			userBucket = usersBucket.Bucket(k)
 
-			var user User
-			var err error
-
-			user.ID, err = toInt64(k)
-			if err != nil {
-				continue
-			}
-			user.Tags, err = toSlice(userBucket.Get([]byte("Tags")))
-			if err != nil {
-				continue
-			}
-			user.Mailout, err = toBool(userBucket.Get([]byte("Mailout")))
+			user, err := readUser(k, userBucket)
 			if err != nil {
 				continue
 			}
@@ -154,6 +270,23 @@ func GetAllUsers() ([]User, error) {
 	return users, nil
 }
 
+// GetActiveUsers возвращает slice, содержащий данные о всех активных пользователях
+// (неактивные пользователи заблокировали бота или удалили аккаунт)
+func GetActiveUsers() ([]User, error) {
+	allUsers, err := GetAllUsers()
+	if err != nil {
+		return []User{}, err
+	}
+
+	users := make([]User, 0, len(allUsers))
+	for _, user := range allUsers {
+		if user.Active {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
 // GetUsersNumber возвращает количество пользователей
 func GetUsersNumber() int64 {
 	var counter int64
@@ -292,3 +425,266 @@ func StopMailout(id string) error {
 
 	return err
 }
+
+// AddUserFeed добавляет RSS/Atom-ленту в подписки пользователя
+// Возвращает slice, содержащий обновлённый список лент
+func AddUserFeed(id string, feedURL string) ([]string, error) {
+	updatedFeeds := make([]string, 0)
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		oldFeeds := toOptionalSlice(userBucket.Get([]byte("Feeds")))
+		updatedFeeds = addTags(oldFeeds, []string{feedURL})
+		// Ленты хранятся в отсортированном виде, чтобы их номера в списке не менялись
+		sort.Strings(updatedFeeds)
+
+		return userBucket.Put([]byte("Feeds"), []byte(strings.Join(updatedFeeds, " ")))
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return updatedFeeds, nil
+}
+
+// DelUserFeed удаляет RSS/Atom-ленту из подписок пользователя
+// Возвращает slice, содержащий обновлённый список лент
+func DelUserFeed(id string, feedURL string) ([]string, error) {
+	updatedFeeds := make([]string, 0)
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		oldFeeds := toOptionalSlice(userBucket.Get([]byte("Feeds")))
+		updatedFeeds = delTags(oldFeeds, []string{feedURL})
+		sort.Strings(updatedFeeds)
+
+		return userBucket.Put([]byte("Feeds"), []byte(strings.Join(updatedFeeds, " ")))
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return updatedFeeds, nil
+}
+
+// AddUserFilter добавляет фильтр по тегам
+// Возвращает slice, содержащий обновлённый список фильтров
+func AddUserFilter(id string, filter string) ([]string, error) {
+	var updatedFilters []string
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		updatedFilters = toJSONSlice(userBucket.Get([]byte("Filters")))
+		for _, f := range updatedFilters {
+			if f == filter {
+				return nil
+			}
+		}
+		updatedFilters = append(updatedFilters, filter)
+
+		raw, err := json.Marshal(updatedFilters)
+		if err != nil {
+			return err
+		}
+		return userBucket.Put([]byte("Filters"), raw)
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return updatedFilters, nil
+}
+
+// DelUserFilter удаляет фильтр с номером number (начиная с 1)
+// Возвращает slice, содержащий обновлённый список фильтров
+func DelUserFilter(id string, number int) ([]string, error) {
+	var updatedFilters []string
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		updatedFilters = toJSONSlice(userBucket.Get([]byte("Filters")))
+		if number < 1 || number > len(updatedFilters) {
+			return ErrNoSuchElement
+		}
+		updatedFilters = append(updatedFilters[:number-1], updatedFilters[number:]...)
+
+		raw, err := json.Marshal(updatedFilters)
+		if err != nil {
+			return err
+		}
+		return userBucket.Put([]byte("Filters"), raw)
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return updatedFilters, nil
+}
+
+// AddUserKeywords добавляет ключевые слова
+// Возвращает slice, содержащий обновлённый список ключевых слов
+func AddUserKeywords(id string, keywords []string) ([]string, error) {
+	updatedKeywords := make([]string, 0)
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		oldKeywords := toOptionalSlice(userBucket.Get([]byte("Keywords")))
+		updatedKeywords = addTags(oldKeywords, keywords)
+		sort.Strings(updatedKeywords)
+
+		return userBucket.Put([]byte("Keywords"), []byte(strings.Join(updatedKeywords, " ")))
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return updatedKeywords, nil
+}
+
+// DelUserKeywords удаляет ключевые слова
+// Возвращает slice, содержащий обновлённый список ключевых слов
+func DelUserKeywords(id string, keywords []string) ([]string, error) {
+	updatedKeywords := make([]string, 0)
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		oldKeywords := toOptionalSlice(userBucket.Get([]byte("Keywords")))
+		updatedKeywords = delTags(oldKeywords, keywords)
+		sort.Strings(updatedKeywords)
+
+		return userBucket.Put([]byte("Keywords"), []byte(strings.Join(updatedKeywords, " ")))
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return updatedKeywords, nil
+}
+
+// AddUserRegexp добавляет регулярное выражение
+// Возвращает slice, содержащий обновлённый список регулярных выражений
+func AddUserRegexp(id string, pattern string) ([]string, error) {
+	var updatedRegexps []string
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		updatedRegexps = toJSONSlice(userBucket.Get([]byte("Regexps")))
+		for _, p := range updatedRegexps {
+			if p == pattern {
+				return nil
+			}
+		}
+		updatedRegexps = append(updatedRegexps, pattern)
+
+		raw, err := json.Marshal(updatedRegexps)
+		if err != nil {
+			return err
+		}
+		return userBucket.Put([]byte("Regexps"), raw)
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return updatedRegexps, nil
+}
+
+// DelUserRegexp удаляет регулярное выражение с номером number (начиная с 1)
+// Возвращает slice, содержащий обновлённый список регулярных выражений
+func DelUserRegexp(id string, number int) ([]string, error) {
+	var updatedRegexps []string
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		updatedRegexps = toJSONSlice(userBucket.Get([]byte("Regexps")))
+		if number < 1 || number > len(updatedRegexps) {
+			return ErrNoSuchElement
+		}
+		updatedRegexps = append(updatedRegexps[:number-1], updatedRegexps[number:]...)
+
+		raw, err := json.Marshal(updatedRegexps)
+		if err != nil {
+			return err
+		}
+		return userBucket.Put([]byte("Regexps"), raw)
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return updatedRegexps, nil
+}
+
+// UpdateUserList добавляет элементы add и удаляет элементы del из списка пользователя, хранящегося в поле field
+// (например, FollowedAuthorsField). Возвращает slice, содержащий обновлённый список
+func UpdateUserList(id string, field string, add, del []string) ([]string, error) {
+	updatedList := make([]string, 0)
+
+	err := dbAdapter.Update(func(tx *bolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("users"))
+
+		userBucket := usersBucket.Bucket([]byte(id))
+		if userBucket == nil {
+			return errors.New("User with id '" + id + "' doesn't exist")
+		}
+
+		oldList := toOptionalSlice(userBucket.Get([]byte(field)))
+		updatedList = delTags(addTags(oldList, add), del)
+		sort.Strings(updatedList)
+
+		return userBucket.Put([]byte(field), []byte(strings.Join(updatedList, " ")))
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return updatedList, nil
+}